@@ -1,40 +1,51 @@
 package main
 
 import (
-	"fmt"
 	"os"
-	"strings"
 
 	"github.com/bitrise-io/go-utils/colorstring"
-	"github.com/bitrise-io/go-utils/stringutil"
 	"github.com/bitrise-io/go-utils/v2/log"
-	"github.com/bitrise-io/go-xcode/v2/xcodecommand"
-	"github.com/bitrise-io/go-xcode/xcodebuild"
-	cache "github.com/bitrise-io/go-xcode/xcodecache"
 )
 
-func runCommandWithRetry(xcodeCommandRunner xcodecommand.Runner, logFormatter string, cmd *xcodebuild.CommandBuilder, swiftPackagesPath string, logger log.Logger) (string, error) {
-	output, err := runCommand(xcodeCommandRunner, logFormatter, cmd, logger)
-	if err != nil && swiftPackagesPath != "" && strings.Contains(output, cache.SwiftPackagesStateInvalid) {
-		logger.Warnf("Analyze failed, swift packages cache is in an invalid state, error: %s", err)
-		if err := os.RemoveAll(swiftPackagesPath); err != nil {
-			return output, fmt.Errorf("failed to remove invalid Swift package caches, error: %s", err)
+// runCommandWithRetry runs the xcodebuild command, and retries it following the retry policy if it fails with a transient error.
+// phase names the command in the log messages.
+func runCommandWithRetry(xcodeCommandRunner *xcodebuildRunner, logFormatter string, logFormatterOpts []string, workDir string, args []string, phase string, policy retryPolicy, logger log.Logger) (xcodebuildOutput, error) {
+	for attempt := 1; ; attempt++ {
+		output, err := runCommand(xcodeCommandRunner, logFormatter, logFormatterOpts, workDir, args, logger)
+
+		failure := policy.shouldRetry(attempt, output, err)
+		if failure == nil {
+			return output, err
+		}
+		if w := xcodeCommandRunner.watchdog; w != nil && !w.hasTimeLeft(policy.delay(*failure, attempt)) {
+			logger.Println()
+			logger.Warnf("%s failed (attempt %d/%d) with a transient error (%s), but it is not retried, as the xcodebuild timeout (%s) is reached", phase, attempt, policy.maxAttempts, failure.name, w.timeout)
+			return output, err
+		}
+
+		logger.Println()
+		logger.Warnf("%s failed (attempt %d/%d) with a transient error (%s), retrying: %s", phase, attempt, policy.maxAttempts, failure.name, err)
+		logger.Printf("Remediation: %s", failure.remediation)
+		var signals <-chan os.Signal
+		if xcodeCommandRunner.watchdog != nil {
+			signals = xcodeCommandRunner.watchdog.signals
+		}
+		if err := policy.remediate(*failure, attempt, signals, logger); err != nil {
+			return output, err
 		}
-		return runCommand(xcodeCommandRunner, logFormatter, cmd, logger)
 	}
-	return output, err
 }
 
-func runCommand(xcodeCommandRunner xcodecommand.Runner, logFormatter string, cmd *xcodebuild.CommandBuilder, logger log.Logger) (string, error) {
-	output, err := xcodeCommandRunner.Run("", cmd.CommandArgs(), []string{})
+func runCommand(xcodeCommandRunner *xcodebuildRunner, logFormatter string, logFormatterOpts []string, workDir string, args []string, logger log.Logger) (xcodebuildOutput, error) {
+	output, err := xcodeCommandRunner.Run(workDir, args, logFormatterOpts)
 	if logFormatter == XcodebuildTool || err != nil {
-		printLastLinesOfXcodebuildLog(logger, string(output.RawOut), err == nil)
+		printLastLinesOfXcodebuildLog(logger, output.LastLines, err == nil)
 	}
 
-	return string(output.RawOut), err
+	return output, err
 }
 
-func printLastLinesOfXcodebuildLog(logger log.Logger, xcodebuildLog string, isXcodebuildSuccess bool) {
+func printLastLinesOfXcodebuildLog(logger log.Logger, lastLines string, isXcodebuildSuccess bool) {
 	const lastLinesMsg = "\nLast lines of the Xcode log:"
 	if isXcodebuildSuccess {
 		logger.Infof(lastLinesMsg)
@@ -42,7 +53,7 @@ func printLastLinesOfXcodebuildLog(logger log.Logger, xcodebuildLog string, isXc
 		logger.Infof(colorstring.Red(lastLinesMsg))
 	}
 
-	logger.Printf("%s", stringutil.LastNLines(xcodebuildLog, 20))
+	logger.Printf("%s", lastLines)
 	logger.Println()
 
 	if !isXcodebuildSuccess {
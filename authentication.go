@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-xcode/xcodebuild"
+)
+
+// apiKey is an App Store Connect API key used by xcodebuild to download missing provisioning profiles.
+type apiKey struct {
+	KeyID    string
+	IssuerID string
+	Path     string
+	Content  string
+}
+
+// isSet reports whether any of the API key inputs are provided.
+func (k apiKey) isSet() bool {
+	return k.KeyID != "" || k.IssuerID != "" || k.Path != "" || k.Content != ""
+}
+
+func (k apiKey) validate() error {
+	if k.KeyID == "" || k.IssuerID == "" {
+		return errors.New("both the API key ID (api_key_id) and issuer ID (api_key_issuer_id) are required")
+	}
+	if k.Path == "" && k.Content == "" {
+		return errors.New("either the API key path (api_key_path) or content (api_key_content) is required")
+	}
+	if k.Path != "" && k.Content != "" {
+		return errors.New("only one of the API key path (api_key_path) and content (api_key_content) can be set")
+	}
+	return nil
+}
+
+// prepareAuthentication returns the xcodebuild authentication parameters for the API key.
+// If the key is given by its content, it is written to a temporary file only readable by the current user.
+// The returned function removes the temporary key file, it is non-nil even if an error is returned.
+func prepareAuthentication(key apiKey) (xcodebuild.AuthenticationParams, func() error, error) {
+	cleanup := func() error { return nil }
+
+	keyPath := key.Path
+	if key.Content != "" {
+		tmpDir, err := os.MkdirTemp("", "api_key")
+		if err != nil {
+			return xcodebuild.AuthenticationParams{}, cleanup, fmt.Errorf("failed to create temporary directory for the API key, error: %s", err)
+		}
+		cleanup = func() error { return os.RemoveAll(tmpDir) }
+
+		keyPath = filepath.Join(tmpDir, fmt.Sprintf("AuthKey_%s.p8", key.KeyID))
+		if err := os.WriteFile(keyPath, []byte(key.Content), 0600); err != nil {
+			return xcodebuild.AuthenticationParams{}, cleanup, fmt.Errorf("failed to write the API key, error: %s", err)
+		}
+	} else if _, err := os.Stat(keyPath); err != nil {
+		return xcodebuild.AuthenticationParams{}, cleanup, fmt.Errorf("API key not found at %s, error: %s", keyPath, err)
+	}
+
+	return xcodebuild.AuthenticationParams{
+		KeyID:     key.KeyID,
+		IsssuerID: key.IssuerID,
+		KeyPath:   keyPath,
+	}, cleanup, nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	buildSettingsAuditNone = "none"
+	buildSettingsAuditWarn = "warn"
+	buildSettingsAuditFail = "fail"
+
+	buildSettingsAuditFilename   = "xcode-analyze-build-settings-audit.json"
+	buildSettingsAuditPathEnvKey = "BITRISE_BUILD_SETTINGS_AUDIT_PATH"
+)
+
+// Build settings rule conditions.
+const (
+	// conditionEquals matches if the setting's value is the rule's value.
+	conditionEquals = "equals"
+	// conditionContainsFlag matches if the (space separated) setting's value contains the rule's value as a separate flag.
+	conditionContainsFlag = "contains_flag"
+	// conditionMatches matches if the setting's value matches the rule's value as a regular expression.
+	conditionMatches = "matches"
+)
+
+// Build settings rule severities, an error fails the Step in fail mode.
+const (
+	severityWarning = "warning"
+	severityError   = "error"
+)
+
+// buildSettingsRule flags the settings, whose name matches the Setting glob pattern (and none of the Except patterns),
+// and whose value satisfies the condition.
+type buildSettingsRule struct {
+	ID        string   `json:"id"`
+	Setting   string   `json:"setting"`
+	Except    []string `json:"except,omitempty"`
+	Condition string   `json:"condition"`
+	Value     string   `json:"value"`
+	Severity  string   `json:"severity"`
+	Message   string   `json:"message"`
+
+	pattern *regexp.Regexp
+}
+
+// buildSettingsPolicy is the set of rules the build settings of the scheme's targets are audited with.
+type buildSettingsPolicy struct {
+	Rules []buildSettingsRule `json:"rules"`
+}
+
+// defaultBuildSettingsPolicy flags the settings which disable or weaken the static analysis, or suppress warnings.
+// The GCC_WARN_* warnings which are disabled by default in Xcode are not flagged.
+var defaultBuildSettingsPolicy = buildSettingsPolicy{
+	Rules: []buildSettingsRule{
+		{
+			ID:        "BSA001",
+			Setting:   "RUN_CLANG_STATIC_ANALYZER",
+			Condition: conditionEquals,
+			Value:     "NO",
+			Severity:  severityError,
+			Message:   "The clang static analyzer is disabled",
+		},
+		{
+			ID:        "BSA002",
+			Setting:   "CLANG_STATIC_ANALYZER_MODE*",
+			Condition: conditionEquals,
+			Value:     "shallow",
+			Severity:  severityWarning,
+			Message:   "The static analyzer runs in shallow mode, which skips the deep analysis of the code paths",
+		},
+		{
+			ID:        "BSA003",
+			Setting:   "CLANG_ANALYZER_*",
+			Condition: conditionEquals,
+			Value:     "NO",
+			Severity:  severityWarning,
+			Message:   "A static analyzer checker is disabled",
+		},
+		{
+			ID:      "BSA004",
+			Setting: "GCC_WARN_*",
+			Except: []string{
+				"GCC_WARN_INHIBIT_ALL_WARNINGS",
+				"GCC_WARN_PEDANTIC",
+				"GCC_WARN_SHADOW",
+				"GCC_WARN_SIGN_COMPARE",
+				"GCC_WARN_UNUSED_PARAMETER",
+				"GCC_WARN_UNUSED_LABEL",
+				"GCC_WARN_UNKNOWN_PRAGMAS",
+				"GCC_WARN_ABOUT_MISSING_PROTOTYPES",
+				"GCC_WARN_ABOUT_MISSING_FIELD_INITIALIZERS",
+				"GCC_WARN_ABOUT_MISSING_NEWLINE",
+				"GCC_WARN_FOUR_CHARACTER_CONSTANTS",
+				"GCC_WARN_HIDDEN_VIRTUAL_FUNCTIONS",
+				"GCC_WARN_INITIALIZER_NOT_FULLY_BRACKETED",
+				"GCC_WARN_MULTIPLE_DEFINITION_TYPES_FOR_SELECTOR",
+				"GCC_WARN_NON_VIRTUAL_DESTRUCTOR",
+				"GCC_WARN_STRICT_SELECTOR_MATCH",
+			},
+			Condition: conditionEquals,
+			Value:     "NO",
+			Severity:  severityWarning,
+			Message:   "A compiler warning, enabled by default, is disabled",
+		},
+		{
+			ID:        "BSA005",
+			Setting:   "GCC_WARN_INHIBIT_ALL_WARNINGS",
+			Condition: conditionEquals,
+			Value:     "YES",
+			Severity:  severityError,
+			Message:   "All compiler warnings are suppressed",
+		},
+		{
+			ID:        "BSA006",
+			Setting:   "OTHER_C*FLAGS",
+			Condition: conditionContainsFlag,
+			Value:     "-w",
+			Severity:  severityError,
+			Message:   "All compiler warnings are suppressed with the -w flag",
+		},
+		{
+			ID:        "BSA007",
+			Setting:   "SWIFT_SUPPRESS_WARNINGS",
+			Condition: conditionEquals,
+			Value:     "YES",
+			Severity:  severityWarning,
+			Message:   "Swift compiler warnings are suppressed",
+		},
+	},
+}
+
+// validate checks the rules of the policy, and compiles their patterns.
+func (p *buildSettingsPolicy) validate() error {
+	ids := map[string]bool{}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.ID == "" {
+			return fmt.Errorf("rule %d has no id", i+1)
+		}
+		if ids[rule.ID] {
+			return fmt.Errorf("duplicate rule id: %s", rule.ID)
+		}
+		ids[rule.ID] = true
+
+		if rule.Setting == "" {
+			return fmt.Errorf("rule %s has no setting", rule.ID)
+		}
+		for _, pattern := range append([]string{rule.Setting}, rule.Except...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %s: invalid setting pattern (%s): %w", rule.ID, pattern, err)
+			}
+		}
+
+		switch rule.Condition {
+		case conditionEquals, conditionContainsFlag:
+		case conditionMatches:
+			pattern, err := regexp.Compile(rule.Value)
+			if err != nil {
+				return fmt.Errorf("rule %s: invalid value pattern (%s): %w", rule.ID, rule.Value, err)
+			}
+			rule.pattern = pattern
+		default:
+			return fmt.Errorf("rule %s: invalid condition (%s), available: %s, %s, %s", rule.ID, rule.Condition, conditionEquals, conditionContainsFlag, conditionMatches)
+		}
+
+		if rule.Severity != severityWarning && rule.Severity != severityError {
+			return fmt.Errorf("rule %s: invalid severity (%s), available: %s, %s", rule.ID, rule.Severity, severityWarning, severityError)
+		}
+	}
+	return nil
+}
+
+// loadBuildSettingsPolicy reads the JSON policy file, or returns the default policy if no path is given.
+func loadBuildSettingsPolicy(pth string) (buildSettingsPolicy, error) {
+	policy := defaultBuildSettingsPolicy
+	if pth != "" {
+		content, err := os.ReadFile(pth)
+		if err != nil {
+			return buildSettingsPolicy{}, err
+		}
+		policy = buildSettingsPolicy{}
+		if err := json.Unmarshal(content, &policy); err != nil {
+			return buildSettingsPolicy{}, fmt.Errorf("failed to parse %s: %w", pth, err)
+		}
+	} else {
+		policy.Rules = append([]buildSettingsRule{}, policy.Rules...)
+	}
+
+	if err := policy.validate(); err != nil {
+		return buildSettingsPolicy{}, err
+	}
+	return policy, nil
+}
+
+// matchesSetting reports whether the rule applies to the setting.
+func (r buildSettingsRule) matchesSetting(setting string) bool {
+	if ok, _ := path.Match(r.Setting, setting); !ok {
+		return false
+	}
+	for _, except := range r.Except {
+		if ok, _ := path.Match(except, setting); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesValue reports whether the setting's value satisfies the rule's condition.
+func (r buildSettingsRule) matchesValue(value string) bool {
+	switch r.Condition {
+	case conditionEquals:
+		return value == r.Value
+	case conditionContainsFlag:
+		for _, flag := range strings.Fields(value) {
+			if flag == r.Value {
+				return true
+			}
+		}
+		return false
+	case conditionMatches:
+		return r.pattern.MatchString(value)
+	default:
+		return false
+	}
+}
+
+// buildSettingFinding is a target's build setting violating a policy rule.
+type buildSettingFinding struct {
+	RuleID        string `json:"rule_id"`
+	Severity      string `json:"severity"`
+	Project       string `json:"project"`
+	Target        string `json:"target"`
+	Configuration string `json:"configuration"`
+	Setting       string `json:"setting"`
+	Value         string `json:"value"`
+	Message       string `json:"message"`
+}
+
+// auditTargetSettings checks the build settings of a target with the policy's rules.
+func auditTargetSettings(policy buildSettingsPolicy, project, target, configuration string, settings map[string]string) []buildSettingFinding {
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var findings []buildSettingFinding
+	for _, rule := range policy.Rules {
+		for _, key := range keys {
+			if !rule.matchesSetting(key) || !rule.matchesValue(settings[key]) {
+				continue
+			}
+			findings = append(findings, buildSettingFinding{
+				RuleID:        rule.ID,
+				Severity:      rule.Severity,
+				Project:       project,
+				Target:        target,
+				Configuration: configuration,
+				Setting:       key,
+				Value:         settings[key],
+				Message:       rule.Message,
+			})
+		}
+	}
+	return findings
+}
+
+// buildSettingOverrides returns the build settings (`KEY=VALUE`) of the xcodebuild options.
+func buildSettingOverrides(customOptions []string) []string {
+	var overrides []string
+	for _, option := range customOptions {
+		if !strings.HasPrefix(option, "-") && strings.Contains(option, "=") {
+			overrides = append(overrides, option)
+		}
+	}
+	return overrides
+}
+
+// parseBuildSettings parses the `<setting> = <value>` lines of an `xcodebuild -showBuildSettings` output.
+func parseBuildSettings(out string) map[string]string {
+	settings := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		key = strings.TrimSpace(key)
+		if ok && key != "" && !strings.Contains(key, " ") {
+			settings[key] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return settings
+}
+
+// auditBuildSettings checks the build settings of the targets built by the scheme with the policy's rules.
+// The configuration of the scheme's analyze action is used, unless the xcodebuild options set one.
+// The build settings are read with the runner, under its watchdog.
+func auditBuildSettings(projectPath, schemeName string, customOptions []string, policy buildSettingsPolicy, workDir string, runner *xcodebuildRunner) ([]buildSettingFinding, error) {
+	scheme, containerPath, err := openScheme(projectPath, schemeName)
+	if err != nil {
+		return nil, err
+	}
+
+	configuration, err := schemeAnalyzeConfiguration(*scheme)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(customOptions); i++ {
+		if customOptions[i] == "-configuration" {
+			configuration = customOptions[i+1]
+		}
+	}
+
+	targets, err := schemeBuildTargets(*scheme, containerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var findings []buildSettingFinding
+	for _, target := range targets {
+		args := []string{"-project", target.ProjectPath, "-target", target.Name}
+		if configuration != "" {
+			args = append(args, "-configuration", configuration)
+		}
+		args = append(append(args, "-showBuildSettings"), buildSettingOverrides(customOptions)...)
+		out, err := runner.Output(workDir, args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the build settings of target (%s): %w", target.Name, err)
+		}
+
+		project := strings.TrimSuffix(filepath.Base(target.ProjectPath), filepath.Ext(target.ProjectPath))
+		findings = append(findings, auditTargetSettings(policy, project, target.Name, configuration, parseBuildSettings(out))...)
+	}
+	return findings, nil
+}
+
+// printBuildSettingsAudit prints the findings as a table.
+func printBuildSettingsAudit(findings []buildSettingFinding, logger log.Logger) {
+	headers := []string{"Rule", "Severity", "Target", "Setting", "Value"}
+	var rows [][]string
+	for _, finding := range findings {
+		rows = append(rows, []string{finding.RuleID, finding.Severity, targetKey(finding.Project, finding.Target), finding.Setting, finding.Value})
+	}
+
+	widths := make([]int, len(headers))
+	for _, row := range append([][]string{headers}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	printRow := func(row []string) {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%-*s", widths[i], cell)
+		}
+		logger.Printf("%s", strings.TrimRight(strings.Join(cells, "  "), " "))
+	}
+	printRow(headers)
+	for _, row := range rows {
+		printRow(row)
+	}
+}
+
+// writeBuildSettingsAudit writes the findings as JSON to the output directory, and returns its path.
+func writeBuildSettingsAudit(findings []buildSettingFinding, outputDir string) (string, error) {
+	pth := filepath.Join(outputDir, buildSettingsAuditFilename)
+	if err := writeJSONFile(pth, struct {
+		Findings []buildSettingFinding `json:"findings"`
+	}{Findings: findings}); err != nil {
+		return "", fmt.Errorf("failed to write build settings audit (%s): %w", pth, err)
+	}
+	return pth, nil
+}
+
+// buildSettingsPolicyError is returned if build settings violate error severity rules of the policy.
+type buildSettingsPolicyError struct {
+	findings []buildSettingFinding
+}
+
+// newBuildSettingsPolicyError returns the error of the error severity findings, or nil if there are none.
+func newBuildSettingsPolicyError(findings []buildSettingFinding) error {
+	var errorFindings []buildSettingFinding
+	for _, finding := range findings {
+		if finding.Severity == severityError {
+			errorFindings = append(errorFindings, finding)
+		}
+	}
+	if len(errorFindings) == 0 {
+		return nil
+	}
+	return &buildSettingsPolicyError{findings: errorFindings}
+}
+
+func (e *buildSettingsPolicyError) Error() string {
+	var violations []string
+	for _, finding := range e.findings {
+		violations = append(violations, fmt.Sprintf("%s %s=%s (%s)", targetKey(finding.Project, finding.Target), finding.Setting, finding.Value, finding.RuleID))
+	}
+	return fmt.Sprintf("%d build settings violate the policy: %s", len(e.findings), strings.Join(violations, ", "))
+}
+
+func isBuildSettingsPolicyError(err error) bool {
+	var policyErr *buildSettingsPolicyError
+	return errors.As(err, &policyErr)
+}
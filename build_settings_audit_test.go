@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditTargetSettingsDefaultPolicy(t *testing.T) {
+	policy, err := loadBuildSettingsPolicy("")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		want     []string
+	}{
+		{
+			name:     "compliant settings",
+			settings: map[string]string{"RUN_CLANG_STATIC_ANALYZER": "YES", "CLANG_STATIC_ANALYZER_MODE": "deep", "GCC_WARN_UNUSED_VARIABLE": "YES", "OTHER_CFLAGS": "-Wall -Wextra"},
+		},
+		{
+			name:     "analyzer disabled",
+			settings: map[string]string{"RUN_CLANG_STATIC_ANALYZER": "NO"},
+			want:     []string{"BSA001 RUN_CLANG_STATIC_ANALYZER"},
+		},
+		{
+			name:     "shallow mode glob",
+			settings: map[string]string{"CLANG_STATIC_ANALYZER_MODE": "shallow", "CLANG_STATIC_ANALYZER_MODE_ON_ANALYZE_ACTION": "shallow"},
+			want:     []string{"BSA002 CLANG_STATIC_ANALYZER_MODE", "BSA002 CLANG_STATIC_ANALYZER_MODE_ON_ANALYZE_ACTION"},
+		},
+		{
+			name:     "warnings disabled by default are not flagged",
+			settings: map[string]string{"GCC_WARN_SHADOW": "NO", "GCC_WARN_UNUSED_VARIABLE": "NO"},
+			want:     []string{"BSA004 GCC_WARN_UNUSED_VARIABLE"},
+		},
+		{
+			name:     "all warnings inhibited is not a disabled warning",
+			settings: map[string]string{"GCC_WARN_INHIBIT_ALL_WARNINGS": "YES"},
+			want:     []string{"BSA005 GCC_WARN_INHIBIT_ALL_WARNINGS"},
+		},
+		{
+			name:     "-w flag, but not as part of another flag",
+			settings: map[string]string{"OTHER_CFLAGS": "-DDEBUG -w", "OTHER_CPLUSPLUSFLAGS": "-Wall -wfoo"},
+			want:     []string{"BSA006 OTHER_CFLAGS"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, finding := range auditTargetSettings(policy, "App", "App", "Debug", tt.settings) {
+				assert.Equal(t, tt.settings[finding.Setting], finding.Value)
+				got = append(got, finding.RuleID+" "+finding.Setting)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoadBuildSettingsPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{name: "valid policy", policy: `{"rules": [{"id": "ORG1", "setting": "SWIFT_VERSION", "condition": "matches", "value": "^4", "severity": "error", "message": "Old Swift"}]}`},
+		{name: "missing id", policy: `{"rules": [{"setting": "A", "condition": "equals", "severity": "error"}]}`, wantErr: "rule 1 has no id"},
+		{name: "duplicate id", policy: `{"rules": [{"id": "A", "setting": "A", "condition": "equals", "severity": "error"}, {"id": "A", "setting": "B", "condition": "equals", "severity": "error"}]}`, wantErr: "duplicate rule id"},
+		{name: "invalid glob", policy: `{"rules": [{"id": "A", "setting": "[A", "condition": "equals", "severity": "error"}]}`, wantErr: "invalid setting pattern"},
+		{name: "invalid regexp", policy: `{"rules": [{"id": "A", "setting": "A", "condition": "matches", "value": "(", "severity": "error"}]}`, wantErr: "invalid value pattern"},
+		{name: "invalid condition", policy: `{"rules": [{"id": "A", "setting": "A", "condition": "contains", "severity": "error"}]}`, wantErr: "invalid condition"},
+		{name: "invalid severity", policy: `{"rules": [{"id": "A", "setting": "A", "condition": "equals", "severity": "fatal"}]}`, wantErr: "invalid severity"},
+		{name: "invalid JSON", policy: `{"rules": `, wantErr: "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "policy.json")
+			if !assert.NoError(t, os.WriteFile(pth, []byte(tt.policy), 0644)) {
+				return
+			}
+
+			policy, err := loadBuildSettingsPolicy(pth)
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				findings := auditTargetSettings(policy, "App", "App", "Debug", map[string]string{"SWIFT_VERSION": "4.2"})
+				assert.Equal(t, []buildSettingFinding{{
+					RuleID: "ORG1", Severity: severityError, Project: "App", Target: "App", Configuration: "Debug",
+					Setting: "SWIFT_VERSION", Value: "4.2", Message: "Old Swift",
+				}}, findings)
+			}
+		})
+	}
+}
+
+func TestNewBuildSettingsPolicyError(t *testing.T) {
+	warning := buildSettingFinding{RuleID: "BSA002", Severity: severityWarning, Project: "App", Target: "App", Setting: "CLANG_STATIC_ANALYZER_MODE", Value: "shallow"}
+	violation := buildSettingFinding{RuleID: "BSA001", Severity: severityError, Project: "App", Target: "Core", Setting: "RUN_CLANG_STATIC_ANALYZER", Value: "NO"}
+
+	assert.NoError(t, newBuildSettingsPolicyError([]buildSettingFinding{warning}))
+
+	err := newBuildSettingsPolicyError([]buildSettingFinding{warning, violation})
+	if assert.Error(t, err) {
+		assert.True(t, isBuildSettingsPolicyError(err))
+		assert.Equal(t, "1 build settings violate the policy: App/Core RUN_CLANG_STATIC_ANALYZER=NO (BSA001)", err.Error())
+	}
+}
+
+func TestBuildSettingOverrides(t *testing.T) {
+	got := buildSettingOverrides([]string{"-configuration", "Release", "RUN_CLANG_STATIC_ANALYZER=NO", "-xcconfig", "a.xcconfig", "-arch=arm64"})
+	assert.Equal(t, []string{"RUN_CLANG_STATIC_ANALYZER=NO"}, got)
+}
+
+func TestParseBuildSettings(t *testing.T) {
+	out := `Command line invocation:
+    /Applications/Xcode.app/Contents/Developer/usr/bin/xcodebuild -project App.xcodeproj -target App -showBuildSettings
+
+Build settings for action build and target App:
+    CLANG_STATIC_ANALYZER_MODE = deep
+    GCC_PREPROCESSOR_DEFINITIONS = DEBUG=1 $(inherited)
+    INFOPLIST_KEY_NSHumanReadableCopyright = "Copyright"
+    OTHER_CFLAGS = `
+
+	assert.Equal(t, map[string]string{
+		"CLANG_STATIC_ANALYZER_MODE":             "deep",
+		"GCC_PREPROCESSOR_DEFINITIONS":           "DEBUG=1 $(inherited)",
+		"INFOPLIST_KEY_NSHumanReadableCopyright": "Copyright",
+		"OTHER_CFLAGS":                           "",
+	}, parseBuildSettings(out))
+}
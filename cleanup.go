@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// cleanupStack reverts the changes the Step makes outside of its output directory
+// (the package credentials, the API key file and the forced code signing settings).
+// Each cleanup runs once: either when its phase is over, or when the remaining ones run, in reverse order of their registration.
+type cleanupStack struct {
+	cleanups []*cleanup
+}
+
+type cleanup struct {
+	// description completes the "Failed to ..." warning of a failed cleanup.
+	description string
+	fn          func() error
+	done        bool
+}
+
+// push registers a cleanup, and returns a function running it right away.
+func (s *cleanupStack) push(description string, fn func() error) func(logger log.Logger) {
+	c := &cleanup{description: description, fn: fn}
+	s.cleanups = append(s.cleanups, c)
+	return c.run
+}
+
+// run runs the cleanups which did not run yet, the last registered first.
+func (s *cleanupStack) run(logger log.Logger) {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		s.cleanups[i].run(logger)
+	}
+}
+
+func (c *cleanup) run(logger log.Logger) {
+	if c.done {
+		return
+	}
+	c.done = true
+
+	if err := c.fn(); err != nil {
+		logger.Warnf("Failed to %s, error: %s", c.description, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanupStack(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.NewLogger(log.WithOutput(&out))
+
+	var calls []string
+	cleanupFunc := func(name string, err error) func() error {
+		return func() error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+
+	var cleanups cleanupStack
+	removeCredentials := cleanups.push("remove the package credentials", cleanupFunc("credentials", nil))
+	cleanups.push("remove the API key", cleanupFunc("api key", errors.New("permission denied")))
+	cleanups.push("restore project files", cleanupFunc("projects", nil))
+
+	// a cleanup run right away does not run again with the remaining ones
+	removeCredentials(logger)
+	removeCredentials(logger)
+	assert.Equal(t, []string{"credentials"}, calls)
+
+	cleanups.run(logger)
+	assert.Equal(t, []string{"credentials", "projects", "api key"}, calls)
+	assert.Contains(t, out.String(), "Failed to remove the API key, error: permission denied")
+
+	cleanups.run(logger)
+	assert.Equal(t, []string{"credentials", "projects", "api key"}, calls)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+)
+
+const (
+	forceCodeSignModeBuildSettings = "build_settings"
+	forceCodeSignModeProject       = "project"
+)
+
+// forceCodeSignBuildSettings returns the xcodebuild build settings forcing the given code signing identity and provisioning profile.
+func forceCodeSignBuildSettings(codeSignIdentity, provisioningProfile string) []string {
+	var settings []string
+	if codeSignIdentity != "" {
+		settings = append(settings, "CODE_SIGN_IDENTITY="+codeSignIdentity)
+	}
+	if provisioningProfile != "" {
+		settings = append(settings, "CODE_SIGN_STYLE=Manual", "PROVISIONING_PROFILE_SPECIFIER="+provisioningProfile)
+	}
+	return settings
+}
+
+// forceCodeSignInProjects rewrites the code signing settings of the scheme's targets in their projects.
+// The returned function restores the original project files, it is non-nil even if an error is returned.
+func forceCodeSignInProjects(projectPath, schemeName, codeSignIdentity, provisioningProfile string, logger log.Logger) (func() error, error) {
+	backups := map[string][]byte{}
+	restore := func() error {
+		for pth, content := range backups {
+			if err := os.WriteFile(pth, content, 0644); err != nil {
+				return fmt.Errorf("failed to restore project file (%s), error: %s", pth, err)
+			}
+		}
+		return nil
+	}
+
+	scheme, containerPath, err := openScheme(projectPath, schemeName)
+	if err != nil {
+		return restore, err
+	}
+
+	configuration, err := schemeAnalyzeConfiguration(*scheme)
+	if err != nil {
+		return restore, err
+	}
+
+	targets, err := schemeBuildTargets(*scheme, containerPath)
+	if err != nil {
+		return restore, err
+	}
+
+	projects := map[string]*xcodeproj.XcodeProj{}
+	for _, target := range targets {
+		project, ok := projects[target.ProjectPath]
+		if !ok {
+			p, err := xcodeproj.Open(target.ProjectPath)
+			if err != nil {
+				return restore, fmt.Errorf("failed to open project (%s), error: %s", target.ProjectPath, err)
+			}
+			project = &p
+			projects[target.ProjectPath] = project
+		}
+
+		developmentTeam, err := targetDevelopmentTeam(*project, target.Name, configuration)
+		if err != nil {
+			return restore, err
+		}
+
+		logger.Printf("Forcing code signing settings of target %s (%s)", target.Name, configuration)
+		if err := project.ForceCodeSign(configuration, target.Name, developmentTeam, codeSignIdentity, provisioningProfile); err != nil {
+			return restore, fmt.Errorf("failed to force code signing settings of target (%s), error: %s", target.Name, err)
+		}
+	}
+
+	for pth, project := range projects {
+		pbxprojPath := filepath.Join(pth, "project.pbxproj")
+		content, err := os.ReadFile(pbxprojPath)
+		if err != nil {
+			return restore, fmt.Errorf("failed to back up project file (%s), error: %s", pbxprojPath, err)
+		}
+		backups[pbxprojPath] = content
+
+		if err := project.Save(); err != nil {
+			return restore, fmt.Errorf("failed to save project (%s), error: %s", pth, err)
+		}
+	}
+
+	return restore, nil
+}
+
+// targetDevelopmentTeam returns the DEVELOPMENT_TEAM build setting of the target's build configuration, as ForceCodeSign overrides it.
+func targetDevelopmentTeam(project xcodeproj.XcodeProj, targetName, configuration string) (string, error) {
+	target, ok := project.Proj.TargetByName(targetName)
+	if !ok {
+		return "", fmt.Errorf("failed to find target with name: %s", targetName)
+	}
+
+	buildConfigurationList, err := project.BuildConfigurationList(target.ID)
+	if err != nil {
+		return "", err
+	}
+	buildConfigurations, err := project.BuildConfigurations(buildConfigurationList)
+	if err != nil {
+		return "", err
+	}
+
+	for _, buildConfiguration := range buildConfigurations {
+		if buildConfiguration["name"] != configuration {
+			continue
+		}
+
+		buildSettings, err := buildConfiguration.Object("buildSettings")
+		if err != nil {
+			return "", err
+		}
+		developmentTeam, err := buildSettings.String("DEVELOPMENT_TEAM")
+		if err != nil && !serialized.IsKeyNotFoundError(err) {
+			return "", err
+		}
+		return developmentTeam, nil
+	}
+
+	return "", nil
+}
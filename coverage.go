@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+)
+
+const (
+	zeroAnalysisCheckNone = "none"
+	zeroAnalysisCheckWarn = "warn"
+	zeroAnalysisCheckFail = "fail"
+)
+
+// analyzeLinePattern matches the xcodebuild output line of an analyzed translation unit, for example:
+// `Analyze /path/to/File.m normal arm64 objective-c ... (in target 'App' from project 'App')`.
+// The spaces in the path are escaped with a backslash.
+var analyzeLinePattern = regexp.MustCompile(`^Analyze(?:Shallow)? ((?:\\.|[^ \\])+) .*\(in target '([^']+)' from project '([^']+)'\)`)
+
+// targetKey identifies a target in the output: `<project name>/<target name>`.
+func targetKey(project, target string) string {
+	return project + "/" + target
+}
+
+// analyzedFilesCollector collects the source files analyzed by xcodebuild per target, from the `Analyze` lines of the output.
+// It is written next to the xcodebuild log (and not behind the log interceptor), so that no line is dropped.
+type analyzedFilesCollector struct {
+	mu      sync.Mutex
+	partial []byte
+	files   map[string]map[string]bool
+}
+
+func newAnalyzedFilesCollector() *analyzedFilesCollector {
+	return &analyzedFilesCollector{files: map[string]map[string]bool{}}
+}
+
+// Write processes the complete lines of p, the last partial line is kept until its newline arrives.
+func (c *analyzedFilesCollector) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.partial = append(c.partial, p...)
+	for {
+		end := bytes.IndexByte(c.partial, '\n')
+		if end < 0 {
+			break
+		}
+		c.processLine(string(c.partial[:end]))
+		c.partial = c.partial[end+1:]
+	}
+	// do not keep the consumed part of the buffer
+	c.partial = append([]byte{}, c.partial...)
+
+	return len(p), nil
+}
+
+func (c *analyzedFilesCollector) processLine(line string) {
+	match := analyzeLinePattern.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if match == nil {
+		return
+	}
+
+	key := targetKey(match[3], match[2])
+	if c.files[key] == nil {
+		c.files[key] = map[string]bool{}
+	}
+	c.files[key][strings.ReplaceAll(match[1], `\ `, " ")] = true
+}
+
+// Files returns the analyzed source files per target.
+func (c *analyzedFilesCollector) Files() map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.partial) > 0 {
+		c.processLine(string(c.partial))
+		c.partial = nil
+	}
+
+	files := map[string][]string{}
+	for key, targetFiles := range c.files {
+		for file := range targetFiles {
+			files[key] = append(files[key], file)
+		}
+		sort.Strings(files[key])
+	}
+	return files
+}
+
+// analyzerReportPaths returns the analyzer reports per target in the project's DerivedData, written since the given time.
+// With a zero time, the reports of the previous (incremental) analyses are included too.
+// The reports are in `Build/Intermediates.noindex/<project>.build/<configuration>/<target>.build/StaticAnalyzer`.
+func analyzerReportPaths(projectDerivedData string, since time.Time) (map[string][]string, error) {
+	intermediatesDir := filepath.Join(projectDerivedData, "Build", "Intermediates.noindex")
+	reports := map[string][]string{}
+	if _, err := os.Stat(intermediatesDir); os.IsNotExist(err) {
+		return reports, nil
+	}
+
+	analyzerDirs, err := findAnalyzerOutputDirs(intermediatesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range analyzerDirs {
+		rel, err := filepath.Rel(intermediatesDir, filepath.Dir(dir))
+		if err != nil {
+			return nil, err
+		}
+		components := strings.Split(rel, string(filepath.Separator))
+		if len(components) < 2 {
+			continue
+		}
+		key := targetKey(strings.TrimSuffix(components[0], ".build"), strings.TrimSuffix(components[len(components)-1], ".build"))
+
+		if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(pth) == ".plist" && !info.ModTime().Before(since) {
+				reports[key] = append(reports[key], pth)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to list the analyzer reports in %s: %w", dir, err)
+		}
+	}
+	return reports, nil
+}
+
+// projectCache opens each project of the scheme's targets once.
+type projectCache map[string]xcodeproj.XcodeProj
+
+func (c projectCache) open(pth string) (xcodeproj.XcodeProj, error) {
+	if project, ok := c[pth]; ok {
+		return project, nil
+	}
+	project, err := xcodeproj.Open(pth)
+	if err != nil {
+		return xcodeproj.XcodeProj{}, fmt.Errorf("failed to open project (%s), error: %s", pth, err)
+	}
+	c[pth] = project
+	return project, nil
+}
+
+// targetCoverage is the static analyzer coverage of a target built by the scheme.
+type targetCoverage struct {
+	Project string `json:"project"`
+	Target  string `json:"target"`
+	// AnalyzableSources is the number of C, Objective-C and C++ sources, checked by the clang static analyzer.
+	AnalyzableSources int `json:"analyzable_sources"`
+	// AnalyzedSources is the number of sources analyzed in this build.
+	AnalyzedSources int `json:"analyzed_sources"`
+	// Reports is the number of the analyzer reports of the target in DerivedData, see analyzerReportsSince.
+	Reports int `json:"reports"`
+}
+
+// analyzerReportsSince returns the time since which the analyzer reports are counted as the analysis of the targets.
+// An incremental analysis does not analyze the unchanged sources again, so the reports of the previous builds are counted;
+// a clean analysis analyzes every source, so only its own reports are counted.
+func analyzerReportsSince(isCleanBuild bool, analyzeStartTime time.Time) time.Time {
+	if isCleanBuild {
+		return analyzeStartTime
+	}
+	return time.Time{}
+}
+
+// notAnalyzed reports whether the target has analyzable sources, but the analyzer did not check any of them.
+func (c targetCoverage) notAnalyzed() bool {
+	return c.AnalyzableSources > 0 && c.AnalyzedSources == 0 && c.Reports == 0
+}
+
+// notAnalyzedError is returned if a target with analyzable sources was not analyzed.
+type notAnalyzedError struct {
+	targets []string
+}
+
+func (e *notAnalyzedError) Error() string {
+	return fmt.Sprintf("the static analyzer did not check any source of the targets: %s", strings.Join(e.targets, ", "))
+}
+
+func isNotAnalyzedError(err error) bool {
+	var notAnalyzedErr *notAnalyzedError
+	return errors.As(err, &notAnalyzedErr)
+}
+
+// analysisCoverage returns the analyzer coverage of the targets built by the scheme, counting the analyzer reports written since the given time.
+func analysisCoverage(projectPath, schemeName, projectDerivedData string, since time.Time, analyzedFiles map[string][]string) ([]targetCoverage, error) {
+	scheme, containerPath, err := openScheme(projectPath, schemeName)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := schemeBuildTargets(*scheme, containerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	reports, err := analyzerReportPaths(projectDerivedData, since)
+	if err != nil {
+		return nil, err
+	}
+
+	projects := projectCache{}
+	var coverage []targetCoverage
+	for _, target := range targets {
+		project, err := projects.open(target.ProjectPath)
+		if err != nil {
+			return nil, err
+		}
+
+		sources, err := targetSources(project, target.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		key := targetKey(project.Name, target.Name)
+		targetCoverage := targetCoverage{
+			Project:         project.Name,
+			Target:          target.Name,
+			AnalyzedSources: len(analyzedFiles[key]),
+			Reports:         len(reports[key]),
+		}
+		for _, source := range sources {
+			if source.Language.isClangAnalyzable() {
+				targetCoverage.AnalyzableSources++
+			}
+		}
+		coverage = append(coverage, targetCoverage)
+	}
+	return coverage, nil
+}
+
+// printAnalysisCoverage prints the coverage table, and returns the targets with analyzable sources which were not analyzed.
+func printAnalysisCoverage(coverage []targetCoverage, logger log.Logger) []string {
+	nameWidth := len("Target")
+	for _, c := range coverage {
+		nameWidth = max(nameWidth, len(targetKey(c.Project, c.Target)))
+	}
+
+	logger.Printf("%-*s  %10s  %8s  %7s", nameWidth, "Target", "Analyzable", "Analyzed", "Reports")
+	var notAnalyzed []string
+	analyzable := 0
+	for _, c := range coverage {
+		logger.Printf("%-*s  %10d  %8d  %7d", nameWidth, targetKey(c.Project, c.Target), c.AnalyzableSources, c.AnalyzedSources, c.Reports)
+		analyzable += c.AnalyzableSources
+		if c.notAnalyzed() {
+			notAnalyzed = append(notAnalyzed, targetKey(c.Project, c.Target))
+		}
+	}
+
+	if analyzable == 0 {
+		logger.Warnf("The scheme's targets have no C, Objective-C or C++ sources, the clang static analyzer has nothing to check (Swift sources are not analyzed)")
+	}
+	return notAnalyzed
+}
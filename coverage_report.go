@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	coverageReportFilename   = "xcode-analyze-coverage-report.json"
+	coverageReportPathEnvKey = "BITRISE_ANALYSIS_COVERAGE_REPORT_PATH"
+)
+
+var (
+	// analyzerExclusionPattern matches the directives excluding code from the static analysis:
+	// `#ifndef __clang_analyzer__` and `#if !defined(__clang_analyzer__)`.
+	analyzerExclusionPattern = regexp.MustCompile(`^\s*#\s*(?:ifndef\s+__clang_analyzer__\b|if\s+!\s*defined\s*\(?\s*__clang_analyzer__\b)`)
+	conditionalStartPattern  = regexp.MustCompile(`^\s*#\s*if`)
+	conditionalElsePattern   = regexp.MustCompile(`^\s*#\s*(?:else|elif)\b`)
+	conditionalEndPattern    = regexp.MustCompile(`^\s*#\s*endif\b`)
+)
+
+// languageCoverage is the sources of a target in a language.
+type languageCoverage struct {
+	Language sourceLanguage `json:"language"`
+	Files    int            `json:"files"`
+	Lines    int            `json:"lines"`
+	// ExcludedLines is the number of lines in `#ifndef __clang_analyzer__` blocks.
+	ExcludedLines int `json:"excluded_lines"`
+	// Analyzed is true if the clang static analyzer checked sources of the language.
+	Analyzed bool `json:"analyzed"`
+}
+
+// targetCoverageReport is the analysis coverage of a target built by the scheme, per source language.
+type targetCoverageReport struct {
+	Project           string             `json:"project"`
+	Target            string             `json:"target"`
+	Languages         []languageCoverage `json:"languages"`
+	AnalyzedLanguages []sourceLanguage   `json:"analyzed_languages"`
+	Lines             int                `json:"lines"`
+	// AnalyzedLines is the number of lines in the analyzed languages, without the lines excluded from the analysis.
+	AnalyzedLines  int `json:"analyzed_lines"`
+	ExcludedLines  int `json:"excluded_lines"`
+	ExcludedBlocks int `json:"excluded_blocks"`
+}
+
+// analysisCoverageReport is the analysis coverage of the scheme's targets.
+type analysisCoverageReport struct {
+	Targets       []targetCoverageReport `json:"targets"`
+	Lines         int                    `json:"lines"`
+	AnalyzedLines int                    `json:"analyzed_lines"`
+}
+
+// analyzedPercent returns the percentage of the lines checked by the analyzer.
+func (r analysisCoverageReport) analyzedPercent() float64 {
+	if r.Lines == 0 {
+		return 0
+	}
+	return float64(r.AnalyzedLines) / float64(r.Lines) * 100
+}
+
+// sourceFileLines counts the lines of a source file, and the lines and blocks excluded from the static analysis.
+// The lines of the `#ifndef __clang_analyzer__` branch are excluded, up to its `#else`, `#elif` or `#endif`.
+func sourceFileLines(pth string) (lines, excludedLines, excludedBlocks int, err error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	excluding := false
+	depth := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lines++
+
+		if !excluding {
+			if analyzerExclusionPattern.MatchString(line) {
+				excluding = true
+				depth = 0
+				excludedBlocks++
+			}
+			continue
+		}
+
+		switch {
+		case conditionalStartPattern.MatchString(line):
+			depth++
+		case conditionalEndPattern.MatchString(line):
+			if depth == 0 {
+				excluding = false
+				continue
+			}
+			depth--
+		case conditionalElsePattern.MatchString(line) && depth == 0:
+			excluding = false
+			continue
+		}
+		excludedLines++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to read %s: %w", pth, err)
+	}
+	return lines, excludedLines, excludedBlocks, nil
+}
+
+// analyzedLanguages returns the languages of the target's sources checked by the analyzer:
+// the sources analyzed in this build, and the sources with an analyzer report of a previous (incremental) analysis.
+// The clang static analyzer names its reports after the source file: `<source file name without extension>.plist`.
+// As the report name has no extension and no directory, a report only marks a language analyzed
+// if the target's clang analyzable sources of that name are all in the same language (`Foo.m` and `Foo.mm` are ambiguous).
+func analyzedLanguages(sources []targetSource, analyzedFiles, reports []string) map[sourceLanguage]bool {
+	languages := map[sourceLanguage]bool{}
+	for _, file := range analyzedFiles {
+		if language, ok := sourceLanguageByExtension[strings.ToLower(filepath.Ext(file))]; ok {
+			languages[language] = true
+		}
+	}
+
+	sourceLanguagesByName := map[string]map[sourceLanguage]bool{}
+	for _, source := range sources {
+		if !source.Language.isClangAnalyzable() {
+			continue
+		}
+		name := filepath.Base(source.Path)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		if sourceLanguagesByName[name] == nil {
+			sourceLanguagesByName[name] = map[sourceLanguage]bool{}
+		}
+		sourceLanguagesByName[name][source.Language] = true
+	}
+	for _, report := range reports {
+		nameLanguages := sourceLanguagesByName[strings.TrimSuffix(filepath.Base(report), filepath.Ext(report))]
+		if len(nameLanguages) != 1 {
+			continue
+		}
+		for language := range nameLanguages {
+			languages[language] = true
+		}
+	}
+	return languages
+}
+
+// newTargetCoverageReport counts the target's sources and their lines per language.
+// The lines of the sources which can not be found (for example the path is relative to the build products directory) are not counted.
+func newTargetCoverageReport(project, target string, sources []targetSource, analyzed map[sourceLanguage]bool) (targetCoverageReport, error) {
+	report := targetCoverageReport{Project: project, Target: target, AnalyzedLanguages: []sourceLanguage{}}
+
+	byLanguage := map[sourceLanguage]*languageCoverage{}
+	for _, source := range sources {
+		coverage, ok := byLanguage[source.Language]
+		if !ok {
+			coverage = &languageCoverage{Language: source.Language, Analyzed: analyzed[source.Language]}
+			byLanguage[source.Language] = coverage
+		}
+		coverage.Files++
+
+		if !filepath.IsAbs(source.Path) {
+			continue
+		}
+		lines, excludedLines, excludedBlocks, err := sourceFileLines(source.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return report, err
+		}
+		coverage.Lines += lines
+		coverage.ExcludedLines += excludedLines
+		report.ExcludedBlocks += excludedBlocks
+	}
+
+	for _, language := range sourceLanguages {
+		coverage, ok := byLanguage[language]
+		if !ok {
+			continue
+		}
+		report.Languages = append(report.Languages, *coverage)
+		report.Lines += coverage.Lines
+		report.ExcludedLines += coverage.ExcludedLines
+		if coverage.Analyzed {
+			report.AnalyzedLanguages = append(report.AnalyzedLanguages, language)
+			report.AnalyzedLines += coverage.Lines - coverage.ExcludedLines
+		}
+	}
+	return report, nil
+}
+
+// newAnalysisCoverageReport creates the analysis coverage report of the targets built by the scheme,
+// from the targets' sources in the project files, the sources analyzed in this build, and the analyzer reports in DerivedData.
+func newAnalysisCoverageReport(projectPath, schemeName, projectDerivedData string, analyzedFiles map[string][]string) (analysisCoverageReport, error) {
+	scheme, containerPath, err := openScheme(projectPath, schemeName)
+	if err != nil {
+		return analysisCoverageReport{}, err
+	}
+	targets, err := schemeBuildTargets(*scheme, containerPath)
+	if err != nil {
+		return analysisCoverageReport{}, err
+	}
+
+	// the sources analyzed by a previous incremental build are still covered, so the previous reports are counted too
+	reports, err := analyzerReportPaths(projectDerivedData, time.Time{})
+	if err != nil {
+		return analysisCoverageReport{}, err
+	}
+
+	projects := projectCache{}
+	report := analysisCoverageReport{Targets: []targetCoverageReport{}}
+	for _, target := range targets {
+		project, err := projects.open(target.ProjectPath)
+		if err != nil {
+			return analysisCoverageReport{}, err
+		}
+
+		sources, err := targetSources(project, target.Name)
+		if err != nil {
+			return analysisCoverageReport{}, err
+		}
+
+		key := targetKey(project.Name, target.Name)
+		targetReport, err := newTargetCoverageReport(project.Name, target.Name, sources, analyzedLanguages(sources, analyzedFiles[key], reports[key]))
+		if err != nil {
+			return analysisCoverageReport{}, fmt.Errorf("failed to count the sources of target (%s): %w", target.Name, err)
+		}
+		report.Targets = append(report.Targets, targetReport)
+		report.Lines += targetReport.Lines
+		report.AnalyzedLines += targetReport.AnalyzedLines
+	}
+	return report, nil
+}
+
+// writeCoverageReport writes the report as JSON to the output directory, and returns its path.
+func writeCoverageReport(report analysisCoverageReport, outputDir string) (string, error) {
+	pth := filepath.Join(outputDir, coverageReportFilename)
+	if err := writeJSONFile(pth, report); err != nil {
+		return "", fmt.Errorf("failed to write analysis coverage report (%s): %w", pth, err)
+	}
+	return pth, nil
+}
+
+// loadCoverageReport reads the report written by writeCoverageReport.
+func loadCoverageReport(pth string) (analysisCoverageReport, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return analysisCoverageReport{}, err
+	}
+	var report analysisCoverageReport
+	if err := json.Unmarshal(content, &report); err != nil {
+		return analysisCoverageReport{}, fmt.Errorf("failed to parse analysis coverage report (%s): %w", pth, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceFileLines(t *testing.T) {
+	tests := []struct {
+		name               string
+		source             []string
+		wantExcludedLines  int
+		wantExcludedBlocks int
+	}{
+		{
+			name:   "no exclusion",
+			source: []string{"#import <Foundation/Foundation.h>", "#ifdef DEBUG", "int x = 1;", "#endif"},
+		},
+		{
+			name:               "ifndef block",
+			source:             []string{"int a;", "#ifndef __clang_analyzer__", "int b;", "int c;", "#endif", "int d;"},
+			wantExcludedLines:  2,
+			wantExcludedBlocks: 1,
+		},
+		{
+			name:               "if not defined block, with spaces around the directive",
+			source:             []string{"  #  if !defined(__clang_analyzer__)", "int b;", "# endif"},
+			wantExcludedLines:  1,
+			wantExcludedBlocks: 1,
+		},
+		{
+			name:               "if not defined without parentheses",
+			source:             []string{"#if !defined __clang_analyzer__", "int b;", "#endif"},
+			wantExcludedLines:  1,
+			wantExcludedBlocks: 1,
+		},
+		{
+			name:               "else branch is analyzed",
+			source:             []string{"#ifndef __clang_analyzer__", "int b;", "#else", "int c;", "#endif"},
+			wantExcludedLines:  1,
+			wantExcludedBlocks: 1,
+		},
+		{
+			name:               "elif branch is analyzed",
+			source:             []string{"#ifndef __clang_analyzer__", "int b;", "#elif TARGET_OS_IOS", "int c;", "#endif"},
+			wantExcludedLines:  1,
+			wantExcludedBlocks: 1,
+		},
+		{
+			name: "nested conditionals are part of the excluded block",
+			source: []string{
+				"#ifndef __clang_analyzer__",
+				"#if DEBUG",
+				"int b;",
+				"#else",
+				"int c;",
+				"#endif",
+				"int d;",
+				"#endif",
+				"int e;",
+			},
+			wantExcludedLines:  6,
+			wantExcludedBlocks: 1,
+		},
+		{
+			name:               "nested exclusion is not a separate block",
+			source:             []string{"#ifndef __clang_analyzer__", "#ifndef __clang_analyzer__", "int b;", "#endif", "#endif"},
+			wantExcludedLines:  3,
+			wantExcludedBlocks: 1,
+		},
+		{
+			name:               "multiple blocks",
+			source:             []string{"#ifndef __clang_analyzer__", "int b;", "#endif", "int c;", "#if !defined(__clang_analyzer__)", "int d;", "#endif"},
+			wantExcludedLines:  2,
+			wantExcludedBlocks: 2,
+		},
+		{
+			name:   "other macros are not exclusions",
+			source: []string{"#ifndef __clang_analyzer_extra__", "int b;", "#endif", "#ifdef __clang_analyzer__", "int c;", "#endif"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "main.m")
+			if !assert.NoError(t, os.WriteFile(pth, []byte(strings.Join(tt.source, "\n")+"\n"), 0644)) {
+				return
+			}
+
+			lines, excludedLines, excludedBlocks, err := sourceFileLines(pth)
+			if assert.NoError(t, err) {
+				assert.Equal(t, len(tt.source), lines)
+				assert.Equal(t, tt.wantExcludedLines, excludedLines)
+				assert.Equal(t, tt.wantExcludedBlocks, excludedBlocks)
+			}
+		})
+	}
+}
+
+func TestAnalyzedLanguages(t *testing.T) {
+	sources := []targetSource{
+		{Path: "/src/App/AppDelegate.swift", Language: languageSwift},
+		{Path: "/src/App/Foo.m", Language: languageObjC},
+		{Path: "/src/App/Foo.mm", Language: languageObjCPP},
+		{Path: "/src/App/Model/Bar.c", Language: languageC},
+		{Path: "/src/App/Legacy/Bar.c", Language: languageC},
+		{Path: "/src/App/Engine.cpp", Language: languageCPP},
+	}
+
+	tests := []struct {
+		name          string
+		analyzedFiles []string
+		reports       []string
+		want          map[sourceLanguage]bool
+	}{
+		{
+			name: "nothing analyzed",
+			want: map[sourceLanguage]bool{},
+		},
+		{
+			name:          "analyzed files of this build",
+			analyzedFiles: []string{"/src/App/Foo.mm", "/src/App/README.md"},
+			want:          map[sourceLanguage]bool{languageObjCPP: true},
+		},
+		{
+			name:    "report of a previous analysis",
+			reports: []string{"/dd/App.build/StaticAnalyzer/App/App/normal/arm64/Engine.plist"},
+			want:    map[sourceLanguage]bool{languageCPP: true},
+		},
+		{
+			name:    "same file names in the same language",
+			reports: []string{"/dd/App.build/StaticAnalyzer/App/App/normal/arm64/Bar.plist"},
+			want:    map[sourceLanguage]bool{languageC: true},
+		},
+		{
+			name:    "same file names in different languages are ambiguous",
+			reports: []string{"/dd/App.build/StaticAnalyzer/App/App/normal/arm64/Foo.plist"},
+			want:    map[sourceLanguage]bool{},
+		},
+		{
+			name:    "swift sources are not matched",
+			reports: []string{"/dd/App.build/StaticAnalyzer/App/App/normal/arm64/AppDelegate.plist"},
+			want:    map[sourceLanguage]bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, analyzedLanguages(sources, tt.analyzedFiles, tt.reports))
+		})
+	}
+}
+
+func TestNewTargetCoverageReport(t *testing.T) {
+	srcDir := t.TempDir()
+	writeTestFiles(t, srcDir, map[string]string{
+		"main.m":       "int a;\n#ifndef __clang_analyzer__\nint b;\n#endif\n",
+		"Legacy/Foo.m": "int c;\n",
+		"View.swift":   "let d = 1\nlet e = 2\n",
+	})
+	sources := []targetSource{
+		{Path: filepath.Join(srcDir, "View.swift"), Language: languageSwift},
+		{Path: filepath.Join(srcDir, "main.m"), Language: languageObjC},
+		{Path: filepath.Join(srcDir, "Legacy", "Foo.m"), Language: languageObjC},
+		{Path: filepath.Join(srcDir, "Missing.m"), Language: languageObjC},
+		{Path: "Generated.c", Language: languageC},
+	}
+
+	report, err := newTargetCoverageReport("App", "App", sources, map[sourceLanguage]bool{languageObjC: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, targetCoverageReport{
+			Project: "App",
+			Target:  "App",
+			Languages: []languageCoverage{
+				{Language: languageSwift, Files: 1, Lines: 2},
+				{Language: languageObjC, Files: 3, Lines: 5, ExcludedLines: 1, Analyzed: true},
+				{Language: languageC, Files: 1},
+			},
+			AnalyzedLanguages: []sourceLanguage{languageObjC},
+			Lines:             7,
+			AnalyzedLines:     4,
+			ExcludedLines:     1,
+			ExcludedBlocks:    1,
+		}, report)
+	}
+}
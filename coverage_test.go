@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzerReportPaths(t *testing.T) {
+	derivedData := t.TempDir()
+	intermediatesDir := filepath.Join(derivedData, "Build", "Intermediates.noindex")
+	appReportsDir := filepath.Join(intermediatesDir, "App.build", "Debug-iphonesimulator", "App.build", staticAnalyzerDirName, "App", "App", "normal", "arm64")
+	coreReportsDir := filepath.Join(intermediatesDir, "App.build", "Debug-iphonesimulator", "Core.build", staticAnalyzerDirName, "App", "Core", "normal", "arm64")
+	for _, pth := range []string{
+		filepath.Join(appReportsDir, "main.plist"),
+		filepath.Join(appReportsDir, "main.d"),
+		filepath.Join(coreReportsDir, "Core.plist"),
+		filepath.Join(coreReportsDir, "Model.plist"),
+	} {
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755)) || !assert.NoError(t, os.WriteFile(pth, nil, 0644)) {
+			return
+		}
+	}
+
+	// the Core reports are restored from the cache of a previous build
+	startTime := time.Now()
+	previousBuild := startTime.Add(-time.Hour)
+	for _, name := range []string{"Core.plist", "Model.plist"} {
+		if !assert.NoError(t, os.Chtimes(filepath.Join(coreReportsDir, name), previousBuild, previousBuild)) {
+			return
+		}
+	}
+	future := startTime.Add(time.Minute)
+	if !assert.NoError(t, os.Chtimes(filepath.Join(appReportsDir, "main.plist"), future, future)) {
+		return
+	}
+
+	reports, err := analyzerReportPaths(derivedData, time.Time{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string][]string{
+			targetKey("App", "App"):  {filepath.Join(appReportsDir, "main.plist")},
+			targetKey("App", "Core"): {filepath.Join(coreReportsDir, "Core.plist"), filepath.Join(coreReportsDir, "Model.plist")},
+		}, reports)
+	}
+
+	reports, err = analyzerReportPaths(derivedData, startTime)
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string][]string{
+			targetKey("App", "App"): {filepath.Join(appReportsDir, "main.plist")},
+		}, reports)
+	}
+
+	reports, err = analyzerReportPaths(filepath.Join(t.TempDir(), "missing"), startTime)
+	if assert.NoError(t, err) {
+		assert.Empty(t, reports)
+	}
+}
+
+func TestTargetCoverageNotAnalyzed(t *testing.T) {
+	tests := []struct {
+		name     string
+		coverage targetCoverage
+		want     bool
+	}{
+		{name: "no analyzable sources", coverage: targetCoverage{}, want: false},
+		{name: "sources analyzed in this build", coverage: targetCoverage{AnalyzableSources: 3, AnalyzedSources: 1}, want: false},
+		{name: "unchanged target of an incremental analysis, with the reports of the previous builds", coverage: targetCoverage{AnalyzableSources: 3, Reports: 2}, want: false},
+		{name: "neither analyzed sources nor reports", coverage: targetCoverage{AnalyzableSources: 3}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.coverage.notAnalyzed())
+		})
+	}
+}
+
+func TestAnalyzerReportsSince(t *testing.T) {
+	startTime := time.Now()
+
+	// the incremental analysis counts the reports of the previous builds
+	assert.True(t, analyzerReportsSince(false, startTime).IsZero())
+	assert.Equal(t, startTime, analyzerReportsSince(true, startTime))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/cache"
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	xcodecache "github.com/bitrise-io/go-xcode/xcodecache"
+)
+
+const (
+	staticAnalyzerDirName = "StaticAnalyzer"
+	derivedDataPathFlag   = "-derivedDataPath"
+
+	analyzerIntermediatesCachePathsEnvKey = "BITRISE_ANALYZER_INTERMEDIATES_CACHE_PATHS"
+)
+
+// derivedDataHash returns the unique ID generated by Xcode for a xcodeproj or xcworkspace path,
+// the suffix of the project's DerivedData directory name (`<project name>-<hash>`).
+func derivedDataHash(projectPath string) (string, error) {
+	derivedData, err := projectDerivedDataPath(projectPath)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(derivedData)
+	return name[strings.LastIndex(name, "-")+1:], nil
+}
+
+// derivedDataPathOption returns the DerivedData path set in the xcodebuild options (-derivedDataPath), or an empty string.
+func derivedDataPathOption(customOptions []string) string {
+	pth := ""
+	for i := 0; i+1 < len(customOptions); i++ {
+		if customOptions[i] == derivedDataPathFlag {
+			pth = customOptions[i+1]
+		}
+	}
+	return pth
+}
+
+// projectDerivedDataPath returns the default per project or workspace Xcode DerivedData path,
+// the parent directory of the Swift package cache (SourcePackages) computed by github.com/bitrise-io/go-xcode/xcodecache.
+func projectDerivedDataPath(projectPath string) (string, error) {
+	swiftPackagesPath, err := xcodecache.SwiftPackagesPath(projectPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(swiftPackagesPath), nil
+}
+
+// findAnalyzerOutputDirs returns the static analyzer result directories (StaticAnalyzer) of the project's DerivedData.
+func findAnalyzerOutputDirs(intermediatesDir string) ([]string, error) {
+	var analyzerDirs []string
+	if err := filepath.Walk(intermediatesDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == staticAnalyzerDirName {
+			analyzerDirs = append(analyzerDirs, pth)
+			return filepath.SkipDir
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to search for analyzer output in %s, error: %s", intermediatesDir, err)
+	}
+	return analyzerDirs, nil
+}
+
+// cleanAnalyzerOutput removes the static analyzer results (StaticAnalyzer directories) from the project's DerivedData,
+// so that the next analyze action re-analyzes every translation unit without recompiling the whole project.
+func cleanAnalyzerOutput(projectDerivedData string, logger log.Logger) error {
+	intermediatesDir := filepath.Join(projectDerivedData, "Build", "Intermediates.noindex")
+	if _, err := os.Stat(intermediatesDir); err != nil {
+		if os.IsNotExist(err) {
+			logger.Printf("No build intermediates found at %s, nothing to clean", intermediatesDir)
+			return nil
+		}
+		return err
+	}
+
+	analyzerDirs, err := findAnalyzerOutputDirs(intermediatesDir)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range analyzerDirs {
+		logger.Debugf("Removing analyzer output: %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove analyzer output (%s), error: %s", dir, err)
+		}
+	}
+	logger.Printf("Removed %d analyzer output directories", len(analyzerDirs))
+
+	return nil
+}
+
+// collectAnalyzerReports copies the analyzer result plists written since the given time from the project's DerivedData
+// to the destination directory, keeping their path relative to the build intermediates directory.
+// Returns the number of copied reports.
+func collectAnalyzerReports(projectDerivedData string, since time.Time, destinationDir string) (int, error) {
+	intermediatesDir := filepath.Join(projectDerivedData, "Build", "Intermediates.noindex")
+	if _, err := os.Stat(intermediatesDir); err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	analyzerDirs, err := findAnalyzerOutputDirs(intermediatesDir)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, dir := range analyzerDirs {
+		if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(pth) != ".plist" || info.ModTime().Before(since) {
+				return nil
+			}
+
+			rel, err := filepath.Rel(intermediatesDir, pth)
+			if err != nil {
+				return err
+			}
+			destination := filepath.Join(destinationDir, rel)
+			if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+				return err
+			}
+			if err := command.CopyFile(pth, destination); err != nil {
+				return err
+			}
+			count++
+			return nil
+		}); err != nil {
+			return count, fmt.Errorf("failed to collect analyzer reports from %s, error: %s", dir, err)
+		}
+	}
+
+	return count, nil
+}
+
+// analyzerIntermediatesPaths returns the DerivedData directories reused by an incremental analysis:
+// the Clang module cache and the build intermediates (including the analyzer results).
+func analyzerIntermediatesPaths(derivedData string) []string {
+	return []string{
+		filepath.Join(derivedData, "ModuleCache.noindex"),
+		filepath.Join(derivedData, "Build", "Intermediates.noindex"),
+	}
+}
+
+// collectAnalyzerIntermediates marks the existing analyzer intermediates directories to be added to the cache,
+// and returns them.
+func collectAnalyzerIntermediates(derivedData string) ([]string, error) {
+	var paths []string
+	for _, pth := range analyzerIntermediatesPaths(derivedData) {
+		if _, err := os.Stat(pth); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		paths = append(paths, pth)
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	c := cache.New()
+	c.IncludePath(paths...)
+	if err := c.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit cache, error: %s", err)
+	}
+	return paths, nil
+}
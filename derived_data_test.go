@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDerivedDataPathOption(t *testing.T) {
+	tests := []struct {
+		name          string
+		customOptions []string
+		want          string
+	}{
+		{name: "no options"},
+		{name: "other options", customOptions: []string{"-configuration", "Debug", "COMPILER_INDEX_STORE_ENABLE=NO"}},
+		{name: "derived data path", customOptions: []string{"-configuration", "Debug", "-derivedDataPath", "build/dd"}, want: "build/dd"},
+		{name: "the last one is used", customOptions: []string{"-derivedDataPath", "/tmp/a", "-derivedDataPath", "/tmp/b"}, want: "/tmp/b"},
+		{name: "option without a value", customOptions: []string{"-derivedDataPath"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, derivedDataPathOption(tt.customOptions))
+		})
+	}
+}
+
+func TestProjectDerivedDataPath(t *testing.T) {
+	derivedData, err := projectDerivedDataPath("/src/My App/My App.xcworkspace")
+	if !assert.NoError(t, err) {
+		return
+	}
+	hash, err := derivedDataHash("/src/My App/My App.xcworkspace")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Regexp(t, `^[a-z]{28}$`, hash)
+	assert.Equal(t, filepath.Join(pathutil.UserHomeDir(), "Library", "Developer", "Xcode", "DerivedData", "My_App-"+hash), derivedData)
+
+	_, err = projectDerivedDataPath("My App.xcworkspace")
+	assert.Error(t, err)
+}
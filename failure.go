@@ -0,0 +1,242 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	failureCategoryEnvKey   = "BITRISE_XCODE_ANALYZE_FAILURE_CATEGORY"
+	failureErrorLinesEnvKey = "BITRISE_XCODE_ANALYZE_FAILURE_ERROR_LINES"
+	failureHintEnvKey       = "BITRISE_XCODE_ANALYZE_FAILURE_HINT"
+
+	maxFailureErrorLines = 10
+)
+
+// failureCategory is the machine-readable reason of a failed analysis.
+type failureCategory string
+
+const (
+	failureSchemeNotFound      failureCategory = "scheme_not_found"
+	failureCodeSigning         failureCategory = "code_signing"
+	failurePackageResolution   failureCategory = "package_resolution"
+	failureCompileError        failureCategory = "compile_error"
+	failureLinkerError         failureCategory = "linker_error"
+	failureTimeout             failureCategory = "timeout"
+	failureCancelled           failureCategory = "cancelled"
+	failureNotAnalyzed         failureCategory = "not_analyzed"
+	failureBuildSettingsPolicy failureCategory = "build_settings_policy"
+	failureInfrastructureCrash failureCategory = "infrastructure_crash"
+	failureUnknown             failureCategory = "unknown"
+)
+
+const packageResolutionHint = "Check that the Swift package repositories are reachable with the package_credentials input, and that Package.resolved is committed and up to date."
+
+// compileErrorPattern matches the clang and swiftc error diagnostics: `<file>:<line>:<column>: error: <message>`.
+var compileErrorPattern = regexp.MustCompile(`^(/[^:]+):(\d+):(\d+): (?:fatal )?error: `)
+
+// failureRule classifies the failures whose error lines or last output lines match any of its patterns.
+type failureRule struct {
+	category failureCategory
+	patterns []*regexp.Regexp
+	hint     string
+}
+
+// failureRules are the output based failure classes, in order of precedence.
+// The timeout, cancellation, missing analysis, build settings policy violation and the separate package resolution phase's failure are classified by the returned error.
+var failureRules = []failureRule{
+	{
+		category: failureSchemeNotFound,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`does not contain a scheme named`),
+			regexp.MustCompile(`is not currently configured for the \w+ action`),
+		},
+		hint: "Make sure the scheme exists and is shared (Xcode: Product > Scheme > Manage Schemes > Shared), and that the shared scheme is committed to the repository.",
+	},
+	{
+		category: failureCodeSigning,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`requires a development team`),
+			regexp.MustCompile(`requires a provisioning profile`),
+			regexp.MustCompile(`No profiles for '.*' were found`),
+			regexp.MustCompile(`No signing certificate`),
+			regexp.MustCompile(`doesn't match the entitlements file's value`),
+			regexp.MustCompile(`errSecInternalComponent`),
+			regexp.MustCompile(`Code ?Sign(ing)? error`),
+		},
+		hint: "The analysis does not need code signing: set disable_codesign to yes and clear force_code_sign_identity and force_provisioning_profile. " +
+			"To analyze with code signing, install the forced (or the project's) code signing identity and provisioning profile with a Certificate and profile installer Step before this Step.",
+	},
+	{
+		category: failurePackageResolution,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`Could not resolve package dependencies`),
+			regexp.MustCompile(`Failed to resolve dependencies`),
+			regexp.MustCompile(`Missing package product`),
+		},
+		hint: packageResolutionHint,
+	},
+	{
+		category: failureInfrastructureCrash,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`The build service has encountered an internal inconsistency`),
+			regexp.MustCompile(`Lost connection to the build service`),
+			regexp.MustCompile(`unable to initiate PIF transfer session`),
+			regexp.MustCompile(`unexpected service error`),
+			regexp.MustCompile(`unable to execute command: (Segmentation fault|Killed|Abort trap)`),
+			regexp.MustCompile(`PLEASE submit a bug report`),
+			regexp.MustCompile(`Assertion failure in`),
+			regexp.MustCompile(`No space left on device`),
+		},
+		hint: "Xcode or the build machine failed, not the project: retry the build (see retry_max_attempts), and check the available disk space and memory if it happens again.",
+	},
+	{
+		category: failureLinkerError,
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`Undefined symbols? for architecture`),
+			regexp.MustCompile(`linker command failed`),
+			regexp.MustCompile(`^ld: `),
+			regexp.MustCompile(`duplicate symbols? `),
+		},
+		hint: "Check that the linked frameworks and libraries are built for the analyzed destination, and that no symbol is defined in multiple targets.",
+	},
+	{
+		category: failureCompileError,
+		patterns: []*regexp.Regexp{compileErrorPattern},
+		hint:     "Fix the compile error at the reported location, the project has to build for the analysis.",
+	},
+}
+
+// sourceLocation is a location in a source file.
+type sourceLocation struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// analyzeFailure is the classified reason of a failed analysis.
+type analyzeFailure struct {
+	Category   failureCategory `json:"category"`
+	ErrorLines []string        `json:"error_lines,omitempty"`
+	// Location is the location of the first compile error.
+	Location *sourceLocation `json:"location,omitempty"`
+	Hint     string          `json:"remediation_hint"`
+}
+
+// failureOutputLines returns the error lines found by errorfinder.FindXcodebuildErrors, the transient failure lines and the last lines of the output.
+func failureOutputLines(output xcodebuildOutput) []string {
+	lines := append(append([]string{}, output.ErrorLines...), output.TransientLines...)
+	if output.LastLines != "" {
+		lines = append(lines, strings.Split(output.LastLines, "\n")...)
+	}
+	return lines
+}
+
+// matchingLines returns the distinct lines matching any of the patterns, at most maxFailureErrorLines.
+func matchingLines(lines []string, patterns []*regexp.Regexp) []string {
+	var matching []string
+	seen := map[string]bool{}
+	for _, line := range lines {
+		for _, pattern := range patterns {
+			if !pattern.MatchString(line) || seen[line] {
+				continue
+			}
+			seen[line] = true
+			matching = append(matching, line)
+			break
+		}
+		if len(matching) == maxFailureErrorLines {
+			break
+		}
+	}
+	return matching
+}
+
+// firstCompileErrorLocation returns the location of the first compile error in the lines, or nil.
+func firstCompileErrorLocation(lines []string) *sourceLocation {
+	for _, line := range lines {
+		match := compileErrorPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		lineNumber, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		return &sourceLocation{File: match[1], Line: lineNumber, Column: column}
+	}
+	return nil
+}
+
+// classifyFailure returns the reason of the failed analysis, or nil if it did not fail.
+func classifyFailure(output xcodebuildOutput, err error) *analyzeFailure {
+	if err == nil {
+		return nil
+	}
+
+	keyLines := output.ErrorLines
+	if len(keyLines) > maxFailureErrorLines {
+		keyLines = keyLines[:maxFailureErrorLines]
+	}
+
+	switch {
+	case isTimeoutError(err):
+		return &analyzeFailure{
+			Category:   failureTimeout,
+			ErrorLines: keyLines,
+			Hint:       "xcodebuild was terminated, see the process samples in the output directory for where it hung, and increase xcodebuild_timeout or xcodebuild_no_output_timeout if the analysis was progressing.",
+		}
+	case isCancelledError(err):
+		return &analyzeFailure{
+			Category:   failureCancelled,
+			ErrorLines: keyLines,
+			Hint:       "The build was aborted, the outputs are partial.",
+		}
+	case isNotAnalyzedError(err):
+		return &analyzeFailure{
+			Category:   failureNotAnalyzed,
+			ErrorLines: []string{err.Error()},
+			Hint:       "Make sure RUN_CLANG_STATIC_ANALYZER is not disabled for the targets, and that the targets' sources are compiled by the analyzed scheme.",
+		}
+	case isBuildSettingsPolicyError(err):
+		return &analyzeFailure{
+			Category:   failureBuildSettingsPolicy,
+			ErrorLines: []string{err.Error()},
+			Hint:       "Fix the flagged build settings of the targets (see the build settings audit), or relax the rules in the build_settings_policy_path file.",
+		}
+	}
+
+	// the separate package resolution phase's failures can only be caused by the scheme or the packages
+	resolutionErr := isPackageResolutionError(err)
+
+	lines := failureOutputLines(output)
+	for _, rule := range failureRules {
+		if resolutionErr && rule.category != failureSchemeNotFound && rule.category != failurePackageResolution {
+			continue
+		}
+
+		matching := matchingLines(lines, rule.patterns)
+		if len(matching) == 0 {
+			continue
+		}
+
+		failure := &analyzeFailure{Category: rule.category, ErrorLines: matching, Hint: rule.hint}
+		if rule.category == failureCompileError {
+			failure.Location = firstCompileErrorLocation(matching)
+		}
+		return failure
+	}
+
+	if resolutionErr {
+		return &analyzeFailure{
+			Category:   failurePackageResolution,
+			ErrorLines: keyLines,
+			Hint:       packageResolutionHint,
+		}
+	}
+
+	return &analyzeFailure{
+		Category:   failureUnknown,
+		ErrorLines: keyLines,
+		Hint:       "Check the xcodebuild log (BITRISE_XCODEBUILD_LOG_PATH) for the cause of the failure.",
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	exitErr := errors.New("exit status 65")
+
+	tests := []struct {
+		name         string
+		output       xcodebuildOutput
+		err          error
+		wantCategory failureCategory
+		wantLines    []string
+		wantLocation *sourceLocation
+	}{
+		{
+			name:   "no error",
+			output: xcodebuildOutput{ErrorLines: []string{"error: ignored"}},
+		},
+		{
+			name:         "scheme not found",
+			output:       xcodebuildOutput{ErrorLines: []string{`xcodebuild: error: The project named "App" does not contain a scheme named "Ap".`}},
+			err:          exitErr,
+			wantCategory: failureSchemeNotFound,
+			wantLines:    []string{`xcodebuild: error: The project named "App" does not contain a scheme named "Ap".`},
+		},
+		{
+			name:         "code signing",
+			output:       xcodebuildOutput{ErrorLines: []string{`error: Signing for "App" requires a development team. Select a development team in the Signing & Capabilities editor.`}},
+			err:          exitErr,
+			wantCategory: failureCodeSigning,
+			wantLines:    []string{`error: Signing for "App" requires a development team. Select a development team in the Signing & Capabilities editor.`},
+		},
+		{
+			name:         "package resolution in the last lines",
+			output:       xcodebuildOutput{LastLines: "Resolve Package Graph\nxcodebuild: error: Could not resolve package dependencies:\n  authentication failed"},
+			err:          exitErr,
+			wantCategory: failurePackageResolution,
+			wantLines:    []string{"xcodebuild: error: Could not resolve package dependencies:"},
+		},
+		{
+			name:         "infrastructure crash takes precedence over the compile errors",
+			output:       xcodebuildOutput{ErrorLines: []string{"/src/App/main.m:3:1: error: unknown type name 'foo'", "error: unable to execute command: Segmentation fault: 11"}},
+			err:          exitErr,
+			wantCategory: failureInfrastructureCrash,
+			wantLines:    []string{"error: unable to execute command: Segmentation fault: 11"},
+		},
+		{
+			name:         "infrastructure crash before the last lines",
+			output:       xcodebuildOutput{TransientLines: []string{"error: Lost connection to the build service"}, LastLines: "** ANALYZE FAILED **"},
+			err:          exitErr,
+			wantCategory: failureInfrastructureCrash,
+			wantLines:    []string{"error: Lost connection to the build service"},
+		},
+		{
+			name:         "linker error",
+			output:       xcodebuildOutput{LastLines: "Undefined symbols for architecture arm64:\n  \"_OBJC_CLASS_$_Foo\", referenced from:\nld: symbol(s) not found for architecture arm64"},
+			err:          exitErr,
+			wantCategory: failureLinkerError,
+			wantLines:    []string{"Undefined symbols for architecture arm64:", "ld: symbol(s) not found for architecture arm64"},
+		},
+		{
+			name: "compile errors with the first location, duplicates removed",
+			output: xcodebuildOutput{ErrorLines: []string{
+				"/src/App/main.m:12:5: error: use of undeclared identifier 'foo'",
+				"/src/App/View.swift:7:20: fatal error: module 'Core' not found",
+				"/src/App/main.m:12:5: error: use of undeclared identifier 'foo'",
+			}},
+			err:          exitErr,
+			wantCategory: failureCompileError,
+			wantLines: []string{
+				"/src/App/main.m:12:5: error: use of undeclared identifier 'foo'",
+				"/src/App/View.swift:7:20: fatal error: module 'Core' not found",
+			},
+			wantLocation: &sourceLocation{File: "/src/App/main.m", Line: 12, Column: 5},
+		},
+		{
+			name:         "timeout is classified by the error",
+			output:       xcodebuildOutput{ErrorLines: []string{"/src/App/main.m:12:5: error: use of undeclared identifier 'foo'"}},
+			err:          &timeoutError{timeout: time.Minute},
+			wantCategory: failureTimeout,
+			wantLines:    []string{"/src/App/main.m:12:5: error: use of undeclared identifier 'foo'"},
+		},
+		{
+			name:         "cancelled",
+			err:          &cancelledError{signal: syscall.SIGTERM},
+			wantCategory: failureCancelled,
+		},
+		{
+			name:         "not analyzed",
+			err:          &notAnalyzedError{targets: []string{"App/App"}},
+			wantCategory: failureNotAnalyzed,
+			wantLines:    []string{"the static analyzer did not check any source of the targets: App/App"},
+		},
+		{
+			name:         "package resolution phase only matches the scheme and package rules",
+			output:       xcodebuildOutput{ErrorLines: []string{"/src/Package.swift:3:1: error: expected expression"}},
+			err:          &packageResolutionError{err: exitErr},
+			wantCategory: failurePackageResolution,
+			wantLines:    []string{"/src/Package.swift:3:1: error: expected expression"},
+		},
+		{
+			name:         "unknown",
+			output:       xcodebuildOutput{ErrorLines: []string{"error: something went wrong"}},
+			err:          exitErr,
+			wantCategory: failureUnknown,
+			wantLines:    []string{"error: something went wrong"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyFailure(tt.output, tt.err)
+			if tt.wantCategory == "" {
+				assert.Nil(t, got)
+				return
+			}
+			if assert.NotNil(t, got) {
+				assert.Equal(t, tt.wantCategory, got.Category)
+				assert.Equal(t, tt.wantLines, got.ErrorLines)
+				assert.Equal(t, tt.wantLocation, got.Location)
+				assert.NotEmpty(t, got.Hint)
+			}
+		})
+	}
+}
+
+func TestMatchingLinesLimit(t *testing.T) {
+	var lines []string
+	for i := 0; i < 2*maxFailureErrorLines; i++ {
+		lines = append(lines, "ld: warning "+string(rune('a'+i)))
+	}
+
+	assert.Len(t, matchingLines(lines, []*regexp.Regexp{regexp.MustCompile(`^ld: `)}), maxFailureErrorLines)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/cache"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"howett.net/plist"
+)
+
+const (
+	// findingsStoreFilename is stored in the project's DerivedData directory, and is cached between builds.
+	findingsStoreFilename = "xcode-analyze-findings-store.json"
+	findingsStoreVersion  = 1
+
+	analyzerFindingsFilename = "xcode-analyzer-findings.json"
+
+	analyzerFindingsPathEnvKey = "BITRISE_ANALYZER_FINDINGS_PATH"
+	findingsStorePathEnvKey    = "BITRISE_ANALYZER_FINDINGS_STORE_PATH"
+)
+
+// analyzerFinding is a static analyzer diagnostic.
+type analyzerFinding struct {
+	File string `json:"file"`
+	// TranslationUnit is the analyzer report's path relative to the build intermediates directory (without extension),
+	// which identifies the analyzed source file.
+	TranslationUnit string `json:"translation_unit"`
+	Line            int    `json:"line"`
+	Column          int    `json:"column"`
+	Category        string `json:"category"`
+	Type            string `json:"type"`
+	CheckName       string `json:"check_name"`
+	Description     string `json:"description"`
+	IssueHash       string `json:"issue_hash,omitempty"`
+	// CarriedOver is true if the translation unit was not re-analyzed in this build,
+	// and the finding comes from a previous build.
+	CarriedOver bool `json:"carried_over,omitempty"`
+}
+
+// findingsStore is the findings of the previous builds, with the content hash of the source files they are located in.
+type findingsStore struct {
+	Version  int               `json:"version"`
+	Files    map[string]string `json:"files"`
+	Findings []analyzerFinding `json:"findings"`
+}
+
+// analyzerFindings is the result of the findings merge.
+type analyzerFindings struct {
+	Findings         []analyzerFinding `json:"findings"`
+	CarriedOverCount int               `json:"carried_over_count"`
+}
+
+// parseAnalyzerReport parses the diagnostics of a clang static analyzer plist report.
+func parseAnalyzerReport(pth, translationUnit string) ([]analyzerFinding, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var report struct {
+		Files       []string `plist:"files"`
+		Diagnostics []struct {
+			Description string `plist:"description"`
+			Category    string `plist:"category"`
+			Type        string `plist:"type"`
+			CheckName   string `plist:"check_name"`
+			IssueHash   string `plist:"issue_hash_content_of_line_in_context"`
+			Location    struct {
+				Line   int `plist:"line"`
+				Column int `plist:"col"`
+				File   int `plist:"file"`
+			} `plist:"location"`
+		} `plist:"diagnostics"`
+	}
+	if _, err := plist.Unmarshal(content, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse analyzer report (%s): %w", pth, err)
+	}
+
+	var findings []analyzerFinding
+	for _, diagnostic := range report.Diagnostics {
+		if diagnostic.Location.File < 0 || diagnostic.Location.File >= len(report.Files) {
+			return nil, fmt.Errorf("invalid file index (%d) in analyzer report (%s)", diagnostic.Location.File, pth)
+		}
+		findings = append(findings, analyzerFinding{
+			File:            report.Files[diagnostic.Location.File],
+			TranslationUnit: translationUnit,
+			Line:            diagnostic.Location.Line,
+			Column:          diagnostic.Location.Column,
+			Category:        diagnostic.Category,
+			Type:            diagnostic.Type,
+			CheckName:       diagnostic.CheckName,
+			Description:     diagnostic.Description,
+			IssueHash:       diagnostic.IssueHash,
+		})
+	}
+	return findings, nil
+}
+
+// readAnalyzerReports parses the analyzer reports collected by collectAnalyzerReports.
+// Returns the findings and the analyzed translation units.
+func readAnalyzerReports(reportsDir string) ([]analyzerFinding, map[string]bool, error) {
+	analyzedUnits := map[string]bool{}
+	if _, err := os.Stat(reportsDir); os.IsNotExist(err) {
+		return nil, analyzedUnits, nil
+	}
+
+	var findings []analyzerFinding
+	if err := filepath.Walk(reportsDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(pth) != ".plist" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(reportsDir, pth)
+		if err != nil {
+			return err
+		}
+		translationUnit := strings.TrimSuffix(rel, filepath.Ext(rel))
+
+		reportFindings, err := parseAnalyzerReport(pth, translationUnit)
+		if err != nil {
+			return err
+		}
+		analyzedUnits[translationUnit] = true
+		findings = append(findings, reportFindings...)
+		return nil
+	}); err != nil {
+		return nil, nil, fmt.Errorf("failed to read analyzer reports from %s: %w", reportsDir, err)
+	}
+	return findings, analyzedUnits, nil
+}
+
+// fileContentHash returns the SHA-256 hash of the file's content, or an empty string if the file does not exist.
+func fileContentHash(pth string) (string, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// loadFindingsStore reads the findings store of the previous build.
+// An empty store is returned if it does not exist or has a different version.
+func loadFindingsStore(pth string) (findingsStore, error) {
+	store := findingsStore{Version: findingsStoreVersion, Files: map[string]string{}}
+
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return store, err
+	}
+
+	var previous findingsStore
+	if err := json.Unmarshal(content, &previous); err != nil {
+		return store, fmt.Errorf("failed to parse findings store (%s): %w", pth, err)
+	}
+	if previous.Version != findingsStoreVersion || previous.Files == nil {
+		return store, nil
+	}
+	return previous, nil
+}
+
+// mergeFindings adds the stored findings of the translation units which were not re-analyzed to the current findings,
+// if the source file they are located in did not change since the store was written.
+func mergeFindings(store findingsStore, current []analyzerFinding, analyzedUnits map[string]bool) (analyzerFindings, error) {
+	merged := analyzerFindings{Findings: append([]analyzerFinding{}, current...)}
+
+	hashes := map[string]string{}
+	for _, finding := range store.Findings {
+		if analyzedUnits[finding.TranslationUnit] {
+			continue
+		}
+
+		hash, ok := hashes[finding.File]
+		if !ok {
+			var err error
+			if hash, err = fileContentHash(finding.File); err != nil {
+				return merged, fmt.Errorf("failed to hash %s: %w", finding.File, err)
+			}
+			hashes[finding.File] = hash
+		}
+		if hash == "" || hash != store.Files[finding.File] {
+			continue
+		}
+
+		finding.CarriedOver = true
+		merged.Findings = append(merged.Findings, finding)
+		merged.CarriedOverCount++
+	}
+
+	sort.SliceStable(merged.Findings, func(i, j int) bool {
+		a, b := merged.Findings[i], merged.Findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return merged, nil
+}
+
+// newFindingsStore creates the store of the merged findings, with the current content hash of their source files.
+func newFindingsStore(findings []analyzerFinding) (findingsStore, error) {
+	store := findingsStore{Version: findingsStoreVersion, Files: map[string]string{}}
+	for _, finding := range findings {
+		if _, ok := store.Files[finding.File]; !ok {
+			hash, err := fileContentHash(finding.File)
+			if err != nil {
+				return store, fmt.Errorf("failed to hash %s: %w", finding.File, err)
+			}
+			store.Files[finding.File] = hash
+		}
+
+		finding.CarriedOver = false
+		store.Findings = append(store.Findings, finding)
+	}
+	return store, nil
+}
+
+func writeJSONFile(pth string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pth, content, 0644)
+}
+
+// withRelativePaths returns a copy of the findings with the source file paths relative to the working directory (or to the git root).
+// The findings store keeps the absolute paths, as the files are hashed in the next build.
+func (f analyzerFindings) withRelativePaths(paths pathReporter) analyzerFindings {
+	findings := make([]analyzerFinding, 0, len(f.Findings))
+	for _, finding := range f.Findings {
+		finding.File = paths.rel(finding.File)
+		findings = append(findings, finding)
+	}
+	f.Findings = findings
+	return f
+}
+
+// mergeCachedFindings merges the findings of the collected analyzer reports with the findings of the previous builds,
+// writes the merged findings to the output directory, and updates the findings store, which is marked to be cached.
+// Returns the merged findings and their path.
+func mergeCachedFindings(reportsDir, storePath, outputDir string, paths pathReporter, logger log.Logger) (analyzerFindings, string, error) {
+	store, err := loadFindingsStore(storePath)
+	if err != nil {
+		logger.Warnf("Failed to load the findings of the previous build, error: %s", err)
+	} else {
+		logger.Printf("Loaded %d findings of the previous build from %s", len(store.Findings), storePath)
+	}
+
+	current, analyzedUnits, err := readAnalyzerReports(reportsDir)
+	if err != nil {
+		return analyzerFindings{}, "", err
+	}
+	logger.Printf("%d findings in %d analyzed translation units", len(current), len(analyzedUnits))
+
+	merged, err := mergeFindings(store, current, analyzedUnits)
+	if err != nil {
+		return merged, "", err
+	}
+
+	findingsPath := filepath.Join(outputDir, analyzerFindingsFilename)
+	if err := writeJSONFile(findingsPath, merged.withRelativePaths(paths)); err != nil {
+		return merged, "", fmt.Errorf("failed to write findings (%s): %w", findingsPath, err)
+	}
+
+	newStore, err := newFindingsStore(merged.Findings)
+	if err != nil {
+		return merged, findingsPath, err
+	}
+	if err := os.MkdirAll(filepath.Dir(storePath), 0755); err != nil {
+		return merged, findingsPath, fmt.Errorf("failed to create findings store directory: %w", err)
+	}
+	if err := writeJSONFile(storePath, newStore); err != nil {
+		return merged, findingsPath, fmt.Errorf("failed to write findings store (%s): %w", storePath, err)
+	}
+
+	if err := commitFindingsStoreCache(storePath); err != nil {
+		return merged, findingsPath, err
+	}
+
+	return merged, findingsPath, nil
+}
+
+// commitFindingsStoreCache marks the findings store to be cached.
+func commitFindingsStoreCache(storePath string) error {
+	c := cache.New()
+	c.IncludePath(storePath)
+	if err := c.Commit(); err != nil {
+		return fmt.Errorf("failed to commit cache, error: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAnalyzerReport = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+ <key>diagnostics</key>
+ <array>
+  <dict>
+   <key>description</key><string>Value stored to &apos;x&apos; is never read</string>
+   <key>category</key><string>Dead store</string>
+   <key>type</key><string>Dead assignment</string>
+   <key>check_name</key><string>deadcode.DeadStores</string>
+   <key>issue_hash_content_of_line_in_context</key><string>a1b2c3</string>
+   <key>location</key>
+   <dict>
+    <key>line</key><integer>12</integer>
+    <key>col</key><integer>5</integer>
+    <key>file</key><integer>1</integer>
+   </dict>
+  </dict>
+ </array>
+ <key>files</key>
+ <array>
+  <string>/src/App/main.m</string>
+  <string>/src/App/Util.h</string>
+ </array>
+</dict>
+</plist>`
+
+func TestReadAnalyzerReports(t *testing.T) {
+	reportsDir := t.TempDir()
+	reportPath := filepath.Join(reportsDir, "App.build", "Debug", "App.build", "StaticAnalyzer", "main.plist")
+	if !assert.NoError(t, os.MkdirAll(filepath.Dir(reportPath), 0755)) || !assert.NoError(t, os.WriteFile(reportPath, []byte(testAnalyzerReport), 0644)) {
+		return
+	}
+	translationUnit := filepath.Join("App.build", "Debug", "App.build", "StaticAnalyzer", "main")
+
+	findings, analyzedUnits, err := readAnalyzerReports(reportsDir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []analyzerFinding{{
+			File:            "/src/App/Util.h",
+			TranslationUnit: translationUnit,
+			Line:            12,
+			Column:          5,
+			Category:        "Dead store",
+			Type:            "Dead assignment",
+			CheckName:       "deadcode.DeadStores",
+			Description:     "Value stored to 'x' is never read",
+			IssueHash:       "a1b2c3",
+		}}, findings)
+		assert.Equal(t, map[string]bool{translationUnit: true}, analyzedUnits)
+	}
+
+	findings, analyzedUnits, err = readAnalyzerReports(filepath.Join(reportsDir, "missing"))
+	if assert.NoError(t, err) {
+		assert.Empty(t, findings)
+		assert.Empty(t, analyzedUnits)
+	}
+}
+
+func TestMergeFindings(t *testing.T) {
+	srcDir := t.TempDir()
+	unchanged := filepath.Join(srcDir, "Unchanged.m")
+	changed := filepath.Join(srcDir, "Changed.m")
+	reanalyzed := filepath.Join(srcDir, "Reanalyzed.m")
+	deleted := filepath.Join(srcDir, "Deleted.m")
+	for _, pth := range []string{unchanged, changed, reanalyzed, deleted} {
+		if !assert.NoError(t, os.WriteFile(pth, []byte(filepath.Base(pth)), 0644)) {
+			return
+		}
+	}
+
+	previous := []analyzerFinding{
+		{File: unchanged, TranslationUnit: "Unchanged", Line: 20, CheckName: "core.NullDereference"},
+		{File: unchanged, TranslationUnit: "Unchanged", Line: 3, CheckName: "deadcode.DeadStores"},
+		{File: changed, TranslationUnit: "Changed", Line: 1, CheckName: "deadcode.DeadStores"},
+		{File: reanalyzed, TranslationUnit: "Reanalyzed", Line: 7, CheckName: "deadcode.DeadStores"},
+		{File: deleted, TranslationUnit: "Deleted", Line: 9, CheckName: "deadcode.DeadStores"},
+	}
+	store, err := newFindingsStore(previous)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.NoError(t, os.WriteFile(changed, []byte("changed"), 0644)) || !assert.NoError(t, os.Remove(deleted)) {
+		return
+	}
+
+	current := []analyzerFinding{{File: reanalyzed, TranslationUnit: "Reanalyzed", Line: 8, CheckName: "core.NullDereference"}}
+	merged, err := mergeFindings(store, current, map[string]bool{"Reanalyzed": true})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 2, merged.CarriedOverCount)
+	assert.Equal(t, []analyzerFinding{
+		{File: reanalyzed, TranslationUnit: "Reanalyzed", Line: 8, CheckName: "core.NullDereference"},
+		{File: unchanged, TranslationUnit: "Unchanged", Line: 3, CheckName: "deadcode.DeadStores", CarriedOver: true},
+		{File: unchanged, TranslationUnit: "Unchanged", Line: 20, CheckName: "core.NullDereference", CarriedOver: true},
+	}, merged.Findings)
+
+	// the next store does not mark the findings as carried over, and has the current hash of the files
+	next, err := newFindingsStore(merged.Findings)
+	if assert.NoError(t, err) {
+		assert.False(t, next.Findings[1].CarriedOver)
+		assert.Equal(t, store.Files[unchanged], next.Files[unchanged])
+		assert.Len(t, next.Files, 2)
+	}
+}
+
+func TestLoadFindingsStore(t *testing.T) {
+	dir := t.TempDir()
+	empty := findingsStore{Version: findingsStoreVersion, Files: map[string]string{}}
+
+	store, err := loadFindingsStore(filepath.Join(dir, "missing.json"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, empty, store)
+	}
+
+	oldVersionPath := filepath.Join(dir, "old.json")
+	if assert.NoError(t, writeJSONFile(oldVersionPath, findingsStore{Version: 0, Files: map[string]string{"/src/a.m": "hash"}})) {
+		store, err = loadFindingsStore(oldVersionPath)
+		if assert.NoError(t, err) {
+			assert.Equal(t, empty, store)
+		}
+	}
+
+	currentPath := filepath.Join(dir, "current.json")
+	current := findingsStore{Version: findingsStoreVersion, Files: map[string]string{"/src/a.m": "hash"}, Findings: []analyzerFinding{{File: "/src/a.m", Line: 1}}}
+	if assert.NoError(t, writeJSONFile(currentPath, current)) {
+		store, err = loadFindingsStore(currentPath)
+		if assert.NoError(t, err) {
+			assert.Equal(t, current, store)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/cache"
+	"github.com/bitrise-io/go-steputils/tools"
+	utilscommand "github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	// analyzeFingerprintDirName is stored in the project's DerivedData directory, and is cached between builds.
+	analyzeFingerprintDirName     = "xcode-analyze-fingerprint"
+	analyzeFingerprintRecordName  = "fingerprint.json"
+	analyzeFingerprintOutputsName = "outputs"
+
+	analyzeFingerprintEnvKey = "BITRISE_XCODE_ANALYZE_FINGERPRINT"
+)
+
+// fingerprintExcludedDirNames are not searched for headers: VCS metadata, user data and build products.
+var fingerprintExcludedDirNames = map[string]bool{
+	"xcuserdata":   true,
+	"DerivedData":  true,
+	"build":        true,
+	"node_modules": true,
+}
+
+// fingerprintHeaderExtensions are the files which are not compiled by the targets, but can be included by their sources.
+var fingerprintHeaderExtensions = map[string]bool{
+	".h":         true,
+	".hh":        true,
+	".hpp":       true,
+	".hxx":       true,
+	".pch":       true,
+	".modulemap": true,
+}
+
+// fingerprintBuildSettings are the patterns (path.Match syntax) of the build settings affecting the analysis.
+// The other settings are not part of the fingerprint, as many of them are derived from the environment
+// (for example HOME, USER, PATH, TMPDIR or the CI's environment variables).
+var fingerprintBuildSettings = []string{
+	"RUN_CLANG_STATIC_ANALYZER",
+	"CLANG_STATIC_ANALYZER_*",
+	"CLANG_ANALYZER_*",
+	"CLANG_WARN_*",
+	"GCC_WARN_*",
+	"WARNING_CFLAGS",
+	"OTHER_CFLAGS",
+	"OTHER_CPLUSPLUSFLAGS",
+	"OTHER_SWIFT_FLAGS",
+	"GCC_PREPROCESSOR_DEFINITIONS",
+	"GCC_PREFIX_HEADER",
+	"GCC_C_LANGUAGE_STANDARD",
+	"GCC_OPTIMIZATION_LEVEL",
+	"CLANG_CXX_LANGUAGE_STANDARD",
+	"CLANG_CXX_LIBRARY",
+	"CLANG_ENABLE_OBJC_ARC",
+	"CLANG_ENABLE_MODULES",
+	"SWIFT_VERSION",
+	"SWIFT_ACTIVE_COMPILATION_CONDITIONS",
+	"HEADER_SEARCH_PATHS",
+	"USER_HEADER_SEARCH_PATHS",
+	"SYSTEM_HEADER_SEARCH_PATHS",
+	"FRAMEWORK_SEARCH_PATHS",
+	"ARCHS",
+	"SDK_NAME",
+	"SDK_VERSION",
+	"*_DEPLOYMENT_TARGET",
+}
+
+// analyzeFingerprintRecord is the fingerprint of a successful analysis and its summary,
+// the summary's paths are relative to the output directory.
+type analyzeFingerprintRecord struct {
+	Fingerprint string         `json:"fingerprint"`
+	Summary     analyzeSummary `json:"summary"`
+}
+
+// sourceRoots returns the SRCROOT of the targets in the `xcodebuild -showBuildSettings` output.
+func sourceRoots(buildSettings string) []string {
+	roots := map[string]bool{}
+	scanner := bufio.NewScanner(strings.NewReader(buildSettings))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " = ")
+		if ok && key == "SRCROOT" && value != "" {
+			roots[value] = true
+		}
+	}
+
+	var sorted []string
+	for root := range roots {
+		sorted = append(sorted, root)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// analysisBuildSettings returns the settings of the `xcodebuild -showBuildSettings` output matching fingerprintBuildSettings,
+// as sorted `<target>: <setting> = <value>` lines.
+func analysisBuildSettings(buildSettings string) []string {
+	var settings []string
+	target := ""
+	scanner := bufio.NewScanner(strings.NewReader(buildSettings))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if _, name, ok := strings.Cut(line, " and target "); ok && strings.HasPrefix(line, "Build settings for ") {
+			target = strings.TrimSuffix(name, ":")
+			continue
+		}
+
+		key, _, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		for _, pattern := range fingerprintBuildSettings {
+			if matched, _ := path.Match(pattern, key); matched {
+				settings = append(settings, target+": "+line)
+				break
+			}
+		}
+	}
+	sort.Strings(settings)
+	return settings
+}
+
+// headerFiles returns the header files in the source roots.
+// Hidden and build product directories, and the excluded directories are skipped.
+// The relative excluded directories are resolved against the current directory, the source roots are absolute paths.
+func headerFiles(roots, excludedDirs []string) ([]string, error) {
+	excluded := map[string]bool{}
+	for _, dir := range excludedDirs {
+		if dir == "" {
+			continue
+		}
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the absolute path of %s: %w", dir, err)
+		}
+		excluded[absDir] = true
+	}
+
+	var headers []string
+	for _, root := range roots {
+		if err := filepath.Walk(root, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if pth != root && (strings.HasPrefix(info.Name(), ".") || fingerprintExcludedDirNames[info.Name()] || excluded[pth]) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode().IsRegular() && fingerprintHeaderExtensions[strings.ToLower(filepath.Ext(pth))] {
+				headers = append(headers, pth)
+			}
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("failed to list the headers in %s: %w", root, err)
+		}
+	}
+	return headers, nil
+}
+
+// schemeFingerprintFiles returns the sources compiled by the targets built by the scheme, and the targets' project files.
+// The sources which can not be resolved to an absolute path are left out.
+func schemeFingerprintFiles(projectPath, schemeName string) ([]string, error) {
+	scheme, containerPath, err := openScheme(projectPath, schemeName)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := schemeBuildTargets(*scheme, containerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	projects := projectCache{}
+	var files []string
+	for _, target := range targets {
+		project, err := projects.open(target.ProjectPath)
+		if err != nil {
+			return nil, err
+		}
+		// the per file compiler flags are only in the project file
+		files = append(files, filepath.Join(target.ProjectPath, "project.pbxproj"))
+
+		sources, err := targetSources(project, target.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, source := range sources {
+			if filepath.IsAbs(source.Path) {
+				files = append(files, source.Path)
+			}
+		}
+	}
+	return files, nil
+}
+
+// hashFiles writes the paths and contents of the files to the hash, in path order.
+// The files are hashed once, the missing files are skipped. Returns the number of hashed files.
+func hashFiles(hash io.Writer, files []string) (int, error) {
+	unique := map[string]bool{}
+	for _, pth := range files {
+		unique[pth] = true
+	}
+	var sorted []string
+	for pth := range unique {
+		sorted = append(sorted, pth)
+	}
+	sort.Strings(sorted)
+
+	count := 0
+	for _, pth := range sorted {
+		content, err := os.ReadFile(pth)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, fmt.Errorf("failed to hash %s: %w", pth, err)
+		}
+
+		fmt.Fprintf(hash, "%s\x00", pth)
+		if _, err := hash.Write(append(content, 0)); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// analyzeFingerprint returns the hash of the Xcode version, the analysis relevant build settings,
+// the analyzer configuration and the files. Returns the number of hashed files too.
+func analyzeFingerprint(xcodeVersion, buildSettings string, configuration, files []string) (string, int, error) {
+	hash := sha256.New()
+	parts := append([]string{xcodeVersion}, analysisBuildSettings(buildSettings)...)
+	for _, part := range append(parts, configuration...) {
+		fmt.Fprintf(hash, "%s\x00", part)
+	}
+	count, err := hashFiles(hash, files)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), count, nil
+}
+
+// buildSettingsArgs returns the `xcodebuild -showBuildSettings` arguments of the analyzed scheme, with the analyze command's options.
+func buildSettingsArgs(projectPath, scheme string, customOptions []string) []string {
+	args := append(projectArgs(projectPath, scheme), customOptions...)
+	return append(args, "-showBuildSettings")
+}
+
+// computeAnalyzeFingerprint returns the fingerprint (see analyzeFingerprint) of the analysis: the hashed files are
+// the sources of the scheme's targets, the headers in their source roots, the project files and the Package.resolved.
+// Returns the number of hashed files too. The xcodebuild commands run under the runner's watchdog.
+func computeAnalyzeFingerprint(projectPath, scheme string, customOptions, configuration, excludedDirs []string, workDir string, runner *xcodebuildRunner) (string, int, error) {
+	xcodeVersion, err := runner.Output(workDir, []string{"-version"})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get Xcode version: %w", err)
+	}
+
+	// only the stdout is used, as the warnings on the stderr contain timestamps and process IDs
+	buildSettings, err := runner.Output(workDir, buildSettingsArgs(projectPath, scheme, customOptions))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get the build settings: %w", err)
+	}
+
+	roots := sourceRoots(buildSettings)
+	if len(roots) == 0 {
+		return "", 0, fmt.Errorf("no source root (SRCROOT) found in the build settings")
+	}
+
+	files, err := schemeFingerprintFiles(projectPath, scheme)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to list the sources of the scheme: %w", err)
+	}
+	headers, err := headerFiles(roots, excludedDirs)
+	if err != nil {
+		return "", 0, err
+	}
+	files = append(files, headers...)
+
+	packageResolved, err := packageResolvedPath(projectPath)
+	if err != nil {
+		return "", 0, err
+	}
+	if packageResolved != "" {
+		files = append(files, packageResolved)
+	}
+
+	return analyzeFingerprint(xcodeVersion, buildSettings, configuration, files)
+}
+
+// loadAnalyzeFingerprintRecord reads the fingerprint record of a previous build, or returns nil if it does not exist.
+func loadAnalyzeFingerprintRecord(storeDir string) (*analyzeFingerprintRecord, error) {
+	content, err := os.ReadFile(filepath.Join(storeDir, analyzeFingerprintRecordName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var record analyzeFingerprintRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse the fingerprint record: %w", err)
+	}
+	return &record, nil
+}
+
+// copyPath copies a file or a directory recursively.
+func copyPath(source, destination string) error {
+	return filepath.Walk(source, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, pth)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return utilscommand.CopyFile(pth, target)
+	})
+}
+
+// summaryOutputPaths returns the pointers to the summary's output paths, which are stored with the fingerprint.
+// The xcresult bundle is not stored, as it is written to a temporary directory.
+func summaryOutputPaths(summary *analyzeSummary) []*string {
+	paths := []*string{&summary.XcodebuildLogPath, &summary.AnalyzerReportsDir, &summary.FindingsPath, &summary.CoverageReportPath}
+	for i := range summary.FormatterReportPaths {
+		paths = append(paths, &summary.FormatterReportPaths[i])
+	}
+	return paths
+}
+
+// saveAnalyzeOutputs stores the fingerprint with the outputs of the successful analysis,
+// and marks the store to be cached.
+func saveAnalyzeOutputs(storeDir, outputDir, fingerprint string, summary analyzeSummary) error {
+	if err := os.RemoveAll(storeDir); err != nil {
+		return fmt.Errorf("failed to remove the previous fingerprint record: %w", err)
+	}
+
+	summary.XcresultPath = ""
+	summary.Fingerprint = ""
+	summary.Skipped = false
+	summary.FormatterReportPaths = append([]string{}, summary.FormatterReportPaths...)
+	for _, pth := range summaryOutputPaths(&summary) {
+		if *pth == "" {
+			continue
+		}
+
+		rel, err := filepath.Rel(outputDir, *pth)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("output (%s) is not in the output directory (%s)", *pth, outputDir)
+		}
+		if err := copyPath(*pth, filepath.Join(storeDir, analyzeFingerprintOutputsName, rel)); err != nil {
+			return fmt.Errorf("failed to store output (%s): %w", *pth, err)
+		}
+		*pth = rel
+	}
+
+	content, err := json.MarshalIndent(analyzeFingerprintRecord{Fingerprint: fingerprint, Summary: summary}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the fingerprint record: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(storeDir, analyzeFingerprintRecordName), content, 0644); err != nil {
+		return fmt.Errorf("failed to write the fingerprint record: %w", err)
+	}
+
+	return commitAnalyzeFingerprintCache(storeDir)
+}
+
+// restoreAnalyzeOutputs copies the stored outputs of the record to the output directory,
+// and returns the record's summary with the restored paths.
+func restoreAnalyzeOutputs(storeDir, outputDir string, record analyzeFingerprintRecord) (analyzeSummary, error) {
+	summary := record.Summary
+	summary.FormatterReportPaths = append([]string{}, summary.FormatterReportPaths...)
+	for _, pth := range summaryOutputPaths(&summary) {
+		if *pth == "" {
+			continue
+		}
+
+		destination := filepath.Join(outputDir, *pth)
+		if err := os.RemoveAll(destination); err != nil {
+			return summary, err
+		}
+		if err := copyPath(filepath.Join(storeDir, analyzeFingerprintOutputsName, *pth), destination); err != nil {
+			return summary, fmt.Errorf("failed to restore output (%s): %w", *pth, err)
+		}
+		*pth = destination
+	}
+
+	summary.Skipped = true
+	summary.Fingerprint = record.Fingerprint
+	return summary, nil
+}
+
+// commitAnalyzeFingerprintCache marks the fingerprint store to be cached.
+func commitAnalyzeFingerprintCache(storeDir string) error {
+	c := cache.New()
+	c.IncludePath(storeDir)
+	if err := c.Commit(); err != nil {
+		return fmt.Errorf("failed to commit cache, error: %s", err)
+	}
+	return nil
+}
+
+// exportRestoredOutputs exports the restored outputs which are exported by the analysis too.
+// The xcodebuild log is exported the same way as after an analysis.
+func exportRestoredOutputs(summary analyzeSummary, logger log.Logger) {
+	var envs [][2]string
+	if len(summary.FormatterReportPaths) > 0 {
+		envs = append(envs, [2]string{formatterReportPathsEnvKey, strings.Join(summary.FormatterReportPaths, "|")})
+	}
+	if summary.FindingsPath != "" {
+		envs = append(envs, [2]string{analyzerFindingsPathEnvKey, summary.FindingsPath})
+	}
+	if summary.CoverageReportPath != "" {
+		envs = append(envs, [2]string{coverageReportPathEnvKey, summary.CoverageReportPath})
+	}
+
+	for _, env := range envs {
+		if err := tools.ExportEnvironmentWithEnvman(env[0], env[1]); err != nil {
+			logger.Warnf("Failed to export: %s, error: %s", env[0], err)
+		} else {
+			logger.Printf("Exported %s: %s", env[0], env[1])
+		}
+	}
+}
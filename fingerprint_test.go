@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceRoots(t *testing.T) {
+	buildSettings := `Build settings for action build and target App:
+    PRODUCT_NAME = App
+    SRCROOT = /src/ios
+
+Build settings for action build and target Core:
+    SRCROOT = /src/packages/Core
+    SRCROOT_NAME = Core
+
+Build settings for action build and target AppTests:
+    SRCROOT = /src/ios`
+
+	assert.Equal(t, []string{"/src/ios", "/src/packages/Core"}, sourceRoots(buildSettings))
+	assert.Empty(t, sourceRoots("Build settings for action build and target App:\n    SRCROOT = \n"))
+}
+
+// writeTestFiles creates the files with their contents under the root directory.
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for pth, content := range files {
+		pth = filepath.Join(root, pth)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755)) || !assert.NoError(t, os.WriteFile(pth, []byte(content), 0644)) {
+			t.FailNow()
+		}
+	}
+}
+
+func TestHeaderFiles(t *testing.T) {
+	// the temporary directory can be a symlink (on macOS), while the current directory is always resolved
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	writeTestFiles(t, root, map[string]string{
+		"App/main.m":                        "int main() {}",
+		"App/App.h":                         "@interface App",
+		"App/Prefix.pch":                    "#import <UIKit/UIKit.h>",
+		"App/README.md":                     "# App",
+		"Core/include/Core.hpp":             "class Core {};",
+		"Core/include/module.modulemap":     "module Core {}",
+		".git/HEAD.h":                       "ref: refs/heads/main",
+		"App/App.xcodeproj/xcuserdata/a.h":  "",
+		"DerivedData/App/Build/Generated.h": "",
+		"build/App.app/Headers/App.h":       "",
+		"output/Output.h":                   "",
+		"deploy/Deploy.h":                   "",
+	})
+
+	// the output directory is relative to the current directory, the deploy directory is absolute
+	wd, err := os.Getwd()
+	if !assert.NoError(t, err) || !assert.NoError(t, os.Chdir(root)) {
+		return
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	headers, err := headerFiles([]string{root}, []string{"output", filepath.Join(root, "deploy"), ""})
+	if assert.NoError(t, err) {
+		var want []string
+		for _, pth := range []string{"App/App.h", "App/Prefix.pch", "Core/include/Core.hpp", "Core/include/module.modulemap"} {
+			want = append(want, filepath.Join(root, pth))
+		}
+		assert.Equal(t, want, headers)
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"main.m": "int main() {}", "App.h": "@interface App"})
+	mainPath, header := filepath.Join(root, "main.m"), filepath.Join(root, "App.h")
+
+	hash := func(files []string) ([]byte, int) {
+		var b bytes.Buffer
+		count, err := hashFiles(&b, files)
+		assert.NoError(t, err)
+		return b.Bytes(), count
+	}
+
+	// the files are hashed once, in path order, the missing files are skipped
+	content, count := hash([]string{mainPath, header, mainPath, filepath.Join(root, "missing.m")})
+	assert.Equal(t, 2, count)
+	assert.Equal(t, header+"\x00@interface App\x00"+mainPath+"\x00int main() {}\x00", string(content))
+
+	reordered, _ := hash([]string{header, mainPath})
+	assert.Equal(t, content, reordered)
+
+	writeTestFiles(t, root, map[string]string{"main.m": "int main() { return 1; }"})
+	changed, _ := hash([]string{mainPath, header})
+	assert.NotEqual(t, content, changed)
+}
+
+const testFingerprintBuildSettings = `Build settings for action analyze and target App:
+    ARCHS = arm64
+    CLANG_STATIC_ANALYZER_MODE = deep
+    GCC_PREPROCESSOR_DEFINITIONS = DEBUG=1
+    HOME = /Users/vagrant
+    IPHONEOS_DEPLOYMENT_TARGET = 15.0
+    PATH = /usr/bin:/bin
+    SRCROOT = /src/App
+    USER = vagrant
+
+Build settings for action analyze and target Core:
+    GCC_PREPROCESSOR_DEFINITIONS = CORE=1
+    TMPDIR = /var/folders/tmp`
+
+func TestAnalysisBuildSettings(t *testing.T) {
+	assert.Equal(t, []string{
+		"App: ARCHS = arm64",
+		"App: CLANG_STATIC_ANALYZER_MODE = deep",
+		"App: GCC_PREPROCESSOR_DEFINITIONS = DEBUG=1",
+		"App: IPHONEOS_DEPLOYMENT_TARGET = 15.0",
+		"Core: GCC_PREPROCESSOR_DEFINITIONS = CORE=1",
+	}, analysisBuildSettings(testFingerprintBuildSettings))
+}
+
+func TestAnalyzeFingerprintIgnoresEnvironment(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"main.m": "int main() {}"})
+	files := []string{filepath.Join(root, "main.m")}
+	configuration := []string{"/src/App/App.xcodeproj", "App", "xcpretty"}
+
+	fingerprint := func(buildSettings string) string {
+		fp, count, err := analyzeFingerprint("Xcode 16.0\nBuild version 16A242d", buildSettings, configuration, files)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		return fp
+	}
+
+	// the settings derived from the environment of another build
+	otherEnvironment := strings.NewReplacer(
+		"HOME = /Users/vagrant", "HOME = /Users/runner",
+		"PATH = /usr/bin:/bin", "PATH = /opt/homebrew/bin:/usr/bin:/bin",
+		"USER = vagrant", "USER = runner\n    BITRISE_BUILD_NUMBER = 42",
+		"TMPDIR = /var/folders/tmp", "TMPDIR = /var/folders/other",
+	).Replace(testFingerprintBuildSettings)
+
+	want := fingerprint(testFingerprintBuildSettings)
+	assert.Equal(t, want, fingerprint(otherEnvironment))
+
+	// the analysis related settings change the fingerprint
+	assert.NotEqual(t, want, fingerprint(strings.Replace(testFingerprintBuildSettings, "DEBUG=1", "DEBUG=0", 1)))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/ruby"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/fileutil"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"github.com/bitrise-io/go-xcode/v2/xcodecommand"
+	version "github.com/hashicorp/go-version"
+)
+
+// outputToolChain returns the ordered list of log formatters to try: the selected output tool followed by the fallbacks.
+// xcodebuild (no formatter) is always the last item, as it can't fail to install.
+func outputToolChain(outputTool, fallbacks string) ([]string, error) {
+	var chain []string
+	add := func(tool string) error {
+		tool = strings.TrimSpace(tool)
+		if tool == "" {
+			return nil
+		}
+		if tool != XcbeautifyTool && tool != XcprettyTool && tool != XcodebuildTool {
+			return fmt.Errorf("unknown output tool: %s", tool)
+		}
+		for _, t := range chain {
+			if t == tool {
+				return nil
+			}
+		}
+		chain = append(chain, tool)
+		return nil
+	}
+
+	if err := add(outputTool); err != nil {
+		return nil, err
+	}
+	for _, tool := range strings.Split(fallbacks, ",") {
+		if err := add(tool); err != nil {
+			return nil, err
+		}
+	}
+	if err := add(XcodebuildTool); err != nil {
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// newXcodeCommandRunner creates the xcodebuild runner using the given log formatter, its CheckInstall installs the log formatter.
+// If pinnedVersion is set, the runner's CheckInstall makes sure that exact version of the log formatter is used.
+func newXcodeCommandRunner(outputTool, pinnedVersion string, logger log.Logger, cmdFactory command.Factory) (xcodecommand.Runner, error) {
+	var pinned *version.Version
+	if pinnedVersion != "" && outputTool != XcodebuildTool {
+		v, err := version.NewVersion(pinnedVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s version (%s): %w", outputTool, pinnedVersion, err)
+		}
+		pinned = v
+	}
+
+	switch outputTool {
+	case XcodebuildTool:
+		return xcodecommand.NewRawCommandRunner(logger, cmdFactory), nil
+	case XcbeautifyTool:
+		runner := xcodecommand.NewXcbeautifyRunner(logger, cmdFactory)
+		if pinned == nil {
+			return runner, nil
+		}
+		return &pinnedVersionRunner{Runner: runner, outputTool: outputTool, pinnedVersion: pinned}, nil
+	case XcprettyTool:
+		commandLocator := env.NewCommandLocator()
+		rubyCommandFactory, err := ruby.NewCommandFactory(cmdFactory, commandLocator)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ruby command factory: %w", err)
+		}
+		rubyEnv := ruby.NewEnvironment(rubyCommandFactory, commandLocator, logger)
+
+		runner := xcodecommand.NewXcprettyCommandRunner(logger, cmdFactory, pathutil.NewPathChecker(), fileutil.NewFileManager(), rubyCommandFactory, rubyEnv)
+		if pinned == nil {
+			return runner, nil
+		}
+		return &pinnedXcprettyRunner{
+			Runner:             runner,
+			pinnedVersion:      pinned,
+			logger:             logger,
+			commandFactory:     cmdFactory,
+			rubyCommandFactory: rubyCommandFactory,
+			rubyEnv:            rubyEnv,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown log formatter: %s", outputTool)
+	}
+}
+
+// outputToolSelection is the log formatter used for the xcodebuild output.
+type outputToolSelection struct {
+	Name    string
+	Version *version.Version
+	// ArgsPrefix are the arguments the formatter needs to be started with, before the formatter options.
+	ArgsPrefix []string
+}
+
+// selectOutputTool returns the first log formatter in the chain which is installed (or could be installed).
+// The pinned version only applies to the first log formatter of the chain, the fallbacks use their installed (or latest) version.
+// The log formatter detection logs are debug logs, only the warnings and errors are printed without verbose logging.
+func selectOutputTool(chain []string, pinnedVersion string, logger log.Logger, cmdFactory command.Factory) outputToolSelection {
+	detectionLogger := newDebugLogger(logger)
+	for i, outputTool := range chain {
+		toolPinnedVersion := ""
+		if i == 0 {
+			toolPinnedVersion = pinnedVersion
+		} else if pinnedVersion != "" && outputTool != XcodebuildTool {
+			logger.Warnf("The pinned output tool version (%s) only applies to %s, the installed (or latest) version of %s is used", pinnedVersion, chain[0], outputTool)
+		}
+
+		runner, err := newXcodeCommandRunner(outputTool, toolPinnedVersion, detectionLogger, cmdFactory)
+		if err == nil {
+			var toolVersion *version.Version
+			if toolVersion, err = runner.CheckInstall(); err == nil {
+				selection := outputToolSelection{Name: outputTool, Version: toolVersion}
+				if pinned, ok := runner.(*pinnedXcprettyRunner); ok {
+					selection.ArgsPrefix = []string{pinned.versionSelector()}
+				}
+				return selection
+			}
+		}
+
+		logger.Warnf("Output tool (%s) is not available: %s", outputTool, err)
+		if i+1 < len(chain) {
+			logger.Warnf("Switching to %s for output tool", chain[i+1])
+		}
+	}
+
+	// xcodebuild is the last item of the chain, and it never fails to install
+	return outputToolSelection{Name: XcodebuildTool}
+}
+
+// debugLogger prints the informational logs of the wrapped logger as debug logs, warnings and errors are printed as they are.
+// The vendored runners' CheckInstall logs its progress at info level, debugLogger keeps it behind verbose logging.
+type debugLogger struct {
+	log.Logger
+}
+
+func newDebugLogger(logger log.Logger) log.Logger {
+	return &debugLogger{Logger: logger}
+}
+
+// Infof ...
+func (l *debugLogger) Infof(format string, v ...interface{}) {
+	l.Logger.Debugf(format, v...)
+}
+
+// Printf ...
+func (l *debugLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Debugf(format, v...)
+}
+
+// Donef ...
+func (l *debugLogger) Donef(format string, v ...interface{}) {
+	l.Logger.Debugf(format, v...)
+}
+
+// TInfof ...
+func (l *debugLogger) TInfof(format string, v ...interface{}) {
+	l.Logger.TDebugf(format, v...)
+}
+
+// TPrintf ...
+func (l *debugLogger) TPrintf(format string, v ...interface{}) {
+	l.Logger.TDebugf(format, v...)
+}
+
+// TDonef ...
+func (l *debugLogger) TDonef(format string, v ...interface{}) {
+	l.Logger.TDebugf(format, v...)
+}
+
+// Println ...
+func (l *debugLogger) Println() {}
+
+// pinnedVersionRunner is a runner which fails to install if the installed log formatter's version differs from the pinned one.
+// Unlike the pinned xcpretty gem, the pinned xcbeautify version is not installed by the Step.
+type pinnedVersionRunner struct {
+	xcodecommand.Runner
+	outputTool    string
+	pinnedVersion *version.Version
+}
+
+// CheckInstall ...
+func (r *pinnedVersionRunner) CheckInstall() (*version.Version, error) {
+	installedVersion, err := r.Runner.CheckInstall()
+	if err != nil {
+		return nil, err
+	}
+	if installedVersion == nil || !installedVersion.Equal(r.pinnedVersion) {
+		return nil, fmt.Errorf("%s version %s is pinned, but %s is installed (the Step does not install %s, install the pinned version before this Step)", r.outputTool, r.pinnedVersion, installedVersion, r.outputTool)
+	}
+	return installedVersion, nil
+}
+
+// pinnedXcprettyRunner is an xcpretty runner which installs and uses the pinned xcpretty gem version,
+// even if other versions are installed.
+type pinnedXcprettyRunner struct {
+	xcodecommand.Runner
+	pinnedVersion      *version.Version
+	logger             log.Logger
+	commandFactory     command.Factory
+	rubyCommandFactory ruby.CommandFactory
+	rubyEnv            ruby.Environment
+}
+
+// CheckInstall installs the pinned xcpretty version if it is not installed yet, and returns its version.
+func (r *pinnedXcprettyRunner) CheckInstall() (*version.Version, error) {
+	r.logger.Debugf("Checking if log formatter (xcpretty %s) is installed", r.pinnedVersion.Original())
+
+	installed, err := r.rubyEnv.IsGemInstalled(XcprettyTool, r.pinnedVersion.Original())
+	if err != nil {
+		return nil, err
+	} else if !installed {
+		r.logger.Warnf("xcpretty %s is not installed", r.pinnedVersion.Original())
+		r.logger.Printf("Installing xcpretty %s", r.pinnedVersion.Original())
+
+		for _, cmd := range r.rubyCommandFactory.CreateGemInstall(XcprettyTool, r.pinnedVersion.Original(), false, false, nil) {
+			r.logger.Debugf("$ %s", cmd.PrintableCommandArgs())
+			if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+				return nil, fmt.Errorf("failed to run xcpretty install command (%s): %s: %w", cmd.PrintableCommandArgs(), out, err)
+			}
+		}
+	}
+
+	versionCmd := r.commandFactory.Create(XcprettyTool, []string{r.versionSelector(), "--version"}, nil)
+	out, err := versionCmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get xcpretty version: %s: %w", out, err)
+	}
+
+	installedVersion, err := version.NewVersion(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse xcpretty version (%s): %w", out, err)
+	}
+	if !installedVersion.Equal(r.pinnedVersion) {
+		return nil, fmt.Errorf("xcpretty version %s is pinned, but %s is used", r.pinnedVersion, installedVersion)
+	}
+	return installedVersion, nil
+}
+
+// versionSelector returns the RubyGems executable argument (_VERSION_) selecting the gem version to run.
+func (r *pinnedXcprettyRunner) versionSelector() string {
+	return "_" + r.pinnedVersion.Original() + "_"
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/kballard/go-shellquote"
+)
+
+const formatterReportPathsEnvKey = "BITRISE_FORMATTER_REPORT_PATHS"
+
+// formatterOption describes a log formatter command line option.
+// values lists the accepted values of an option taking a value, it is empty for options without a value,
+// and contains an empty string for options accepting any value.
+type formatterOption struct {
+	values []string
+}
+
+func (o formatterOption) hasValue() bool {
+	return len(o.values) > 0
+}
+
+func (o formatterOption) isValid(value string) bool {
+	for _, v := range o.values {
+		if v == "" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var anyValue = []string{""}
+
+var formatterOptions = map[string]map[string]formatterOption{
+	XcprettyTool: {
+		"--report":      {values: []string{"junit", "html", "json-compilation-database"}},
+		"-r":            {values: []string{"junit", "html", "json-compilation-database"}},
+		"--output":      {values: anyValue},
+		"-o":            {values: anyValue},
+		"--screenshots": {},
+		"--simple":      {},
+		"-s":            {},
+		"--test":        {},
+		"-t":            {},
+		"--knock":       {},
+		"-k":            {},
+		"--tap":         {},
+		"--formatter":   {values: anyValue},
+		"-f":            {values: anyValue},
+		"--color":       {},
+		"-c":            {},
+		"--no-color":    {},
+		"--utf":         {},
+		"--no-utf":      {},
+	},
+	XcbeautifyTool: {
+		"--renderer":               {values: []string{"terminal", "github-actions", "teamcity", "azure-devops-pipelines"}},
+		"--quiet":                  {},
+		"-q":                       {},
+		"--quieter":                {},
+		"-qq":                      {},
+		"--is-ci":                  {},
+		"--disable-colored-output": {},
+		"--preserve-unbeautified":  {},
+		"--disable-logging":        {},
+		"--report":                 {values: []string{"junit"}},
+		"--report-path":            {values: anyValue},
+		"--junit-report-filename":  {values: anyValue},
+	},
+}
+
+// xcprettyReportFilenames are the file names used for xcpretty reports written without an explicit --output path.
+var xcprettyReportFilenames = map[string]string{
+	"junit":                     "xcpretty-junit.xml",
+	"html":                      "xcpretty-report.html",
+	"json-compilation-database": "xcpretty-compilation-database.json",
+}
+
+// parseFormatterOptions shell splits and validates the log formatter options.
+// Relative paths are resolved against the working directory, and reports without an explicit output path are written to outputDir.
+// Returns the formatter arguments and the paths of the report files the formatter is expected to write.
+func parseFormatterOptions(outputTool, options, workdir, outputDir string) ([]string, []string, error) {
+	if strings.TrimSpace(options) == "" {
+		return nil, nil, nil
+	}
+
+	knownOptions, ok := formatterOptions[outputTool]
+	if !ok {
+		return nil, nil, fmt.Errorf("output tool (%s) does not accept formatter options", outputTool)
+	}
+
+	args, err := shellquote.Split(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to shell split formatter options (%s): %w", options, err)
+	}
+
+	type optionValue struct {
+		name  string
+		value string
+	}
+	var parsed []optionValue
+	for i := 0; i < len(args); i++ {
+		name := args[i]
+		option, ok := knownOptions[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown %s option: %s", outputTool, name)
+		}
+		if !option.hasValue() {
+			parsed = append(parsed, optionValue{name: name})
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return nil, nil, fmt.Errorf("missing value for %s option: %s", outputTool, name)
+		}
+		i++
+		value := args[i]
+		if !option.isValid(value) {
+			return nil, nil, fmt.Errorf("invalid value (%s) for %s option %s, available values: %s", value, outputTool, name, strings.Join(option.values, ", "))
+		}
+		parsed = append(parsed, optionValue{name: name, value: value})
+	}
+
+	var formatterArgs, reportPaths []string
+	switch outputTool {
+	case XcprettyTool:
+		for i, opt := range parsed {
+			switch opt.name {
+			case "--output", "-o":
+				opt.value = resolvePath(workdir, opt.value)
+			case "--report", "-r":
+				// the --output option after a --report option sets the report's path
+				if i+1 < len(parsed) && (parsed[i+1].name == "--output" || parsed[i+1].name == "-o") {
+					reportPaths = append(reportPaths, resolvePath(workdir, parsed[i+1].value))
+				} else {
+					reportPath := filepath.Join(outputDir, xcprettyReportFilenames[opt.value])
+					formatterArgs = append(formatterArgs, opt.name, opt.value, "--output", reportPath)
+					reportPaths = append(reportPaths, reportPath)
+					continue
+				}
+			}
+			formatterArgs = append(formatterArgs, opt.name)
+			if opt.value != "" {
+				formatterArgs = append(formatterArgs, opt.value)
+			}
+		}
+	case XcbeautifyTool:
+		hasReport, reportDir, reportFilename := false, "", "junit.xml"
+		for _, opt := range parsed {
+			switch opt.name {
+			case "--report":
+				hasReport = true
+			case "--report-path":
+				opt.value = resolvePath(workdir, opt.value)
+				reportDir = opt.value
+			case "--junit-report-filename":
+				reportFilename = opt.value
+			}
+			formatterArgs = append(formatterArgs, opt.name)
+			if opt.value != "" {
+				formatterArgs = append(formatterArgs, opt.value)
+			}
+		}
+		if hasReport {
+			if reportFilename != filepath.Base(reportFilename) {
+				return nil, nil, fmt.Errorf("invalid value (%s) for %s option --junit-report-filename: a file name is expected, without a directory", reportFilename, outputTool)
+			}
+			if reportDir == "" {
+				reportDir = outputDir
+				formatterArgs = append(formatterArgs, "--report-path", reportDir)
+			}
+			reportPaths = append(reportPaths, filepath.Join(reportDir, reportFilename))
+		}
+	}
+
+	// the reports are collected into the output directory by their file name
+	reportNames := map[string]string{}
+	for _, pth := range reportPaths {
+		if info, err := os.Stat(pth); err == nil && info.IsDir() {
+			return nil, nil, fmt.Errorf("report path (%s) is a directory, a file path is expected", pth)
+		}
+		if other, ok := reportNames[filepath.Base(pth)]; ok {
+			return nil, nil, fmt.Errorf("reports (%s, %s) have the same file name, set different output paths", other, pth)
+		}
+		reportNames[filepath.Base(pth)] = pth
+	}
+
+	return formatterArgs, reportPaths, nil
+}
+
+// removeFormatterReports removes the report files of a previous run, so that only the reports of this run are collected.
+// Only regular files are removed, a report path pointing to a directory or another kind of file is an error.
+func removeFormatterReports(reportPaths []string) error {
+	for _, pth := range reportPaths {
+		info, err := os.Lstat(pth)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("report path (%s) is not a regular file", pth)
+		}
+		if err := os.Remove(pth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectFormatterReports copies the existing report files into the output directory and returns their paths there.
+// The reports are copied by their file name, reports with the same file name are an error.
+func collectFormatterReports(reportPaths []string, outputDir string) ([]string, error) {
+	var existing []string
+	sources := map[string]string{}
+	for _, pth := range reportPaths {
+		if _, err := os.Stat(pth); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		if source, ok := sources[filepath.Base(pth)]; ok {
+			return nil, fmt.Errorf("reports (%s, %s) have the same file name, they would overwrite each other in the output directory", source, pth)
+		}
+		sources[filepath.Base(pth)] = pth
+		existing = append(existing, pth)
+	}
+
+	var collected []string
+	for _, pth := range existing {
+		destination := pth
+		if filepath.Dir(pth) != filepath.Clean(outputDir) {
+			destination = filepath.Join(outputDir, filepath.Base(pth))
+			if err := command.CopyFile(pth, destination); err != nil {
+				return nil, fmt.Errorf("failed to copy report (%s) to the output directory: %w", pth, err)
+			}
+		}
+		collected = append(collected, destination)
+	}
+	return collected, nil
+}
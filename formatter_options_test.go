@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormatterOptions(t *testing.T) {
+	workdir := t.TempDir()
+	existingDir := filepath.Join(workdir, "reports")
+	if !assert.NoError(t, os.Mkdir(existingDir, 0755)) {
+		return
+	}
+
+	tests := []struct {
+		name            string
+		outputTool      string
+		options         string
+		wantArgs        []string
+		wantReportPaths []string
+		wantErr         string
+	}{
+		{name: "empty options", outputTool: XcprettyTool, options: "  "},
+		{name: "xcodebuild does not accept options", outputTool: XcodebuildTool, options: "--simple", wantErr: "does not accept formatter options"},
+		{name: "unknown option", outputTool: XcprettyTool, options: "--fancy", wantErr: "unknown xcpretty option: --fancy"},
+		{name: "missing value", outputTool: XcprettyTool, options: "--simple --report", wantErr: "missing value for xcpretty option: --report"},
+		{name: "invalid value", outputTool: XcprettyTool, options: "--report pdf", wantErr: "invalid value (pdf) for xcpretty option --report"},
+		{name: "shell split error", outputTool: XcprettyTool, options: `--formatter "unclosed`, wantErr: "failed to shell split"},
+		{
+			name:       "options without value",
+			outputTool: XcprettyTool,
+			options:    "--simple --no-color",
+			wantArgs:   []string{"--simple", "--no-color"},
+		},
+		{
+			name:            "xcpretty report without output is written to the output directory",
+			outputTool:      XcprettyTool,
+			options:         "--report junit -r html",
+			wantArgs:        []string{"--report", "junit", "--output", "/out/xcpretty-junit.xml", "-r", "html", "--output", "/out/xcpretty-report.html"},
+			wantReportPaths: []string{"/out/xcpretty-junit.xml", "/out/xcpretty-report.html"},
+		},
+		{
+			name:            "xcpretty output after the report is paired with it, relative to the working directory",
+			outputTool:      XcprettyTool,
+			options:         "--report junit -o build/junit.xml --report html",
+			wantArgs:        []string{"--report", "junit", "-o", filepath.Join(workdir, "build/junit.xml"), "--report", "html", "--output", "/out/xcpretty-report.html"},
+			wantReportPaths: []string{filepath.Join(workdir, "build/junit.xml"), "/out/xcpretty-report.html"},
+		},
+		{
+			name:            "xcpretty absolute output",
+			outputTool:      XcprettyTool,
+			options:         "--report json-compilation-database --output /tmp/compile_commands.json",
+			wantArgs:        []string{"--report", "json-compilation-database", "--output", "/tmp/compile_commands.json"},
+			wantReportPaths: []string{"/tmp/compile_commands.json"},
+		},
+		{
+			name:       "xcpretty output pointing to a directory",
+			outputTool: XcprettyTool,
+			options:    "--report junit --output .",
+			wantErr:    "is a directory",
+		},
+		{
+			name:       "xcpretty reports with the same file name",
+			outputTool: XcprettyTool,
+			options:    "--report junit --output a/report.xml --report html --output b/report.xml",
+			wantErr:    "have the same file name",
+		},
+		{
+			name:       "xcbeautify options without report",
+			outputTool: XcbeautifyTool,
+			options:    "--renderer github-actions --quieter",
+			wantArgs:   []string{"--renderer", "github-actions", "--quieter"},
+		},
+		{
+			name:            "xcbeautify report is written to the output directory",
+			outputTool:      XcbeautifyTool,
+			options:         "--report junit",
+			wantArgs:        []string{"--report", "junit", "--report-path", "/out"},
+			wantReportPaths: []string{"/out/junit.xml"},
+		},
+		{
+			name:            "xcbeautify report path is relative to the working directory",
+			outputTool:      XcbeautifyTool,
+			options:         "--report junit --report-path reports --junit-report-filename analyze.xml",
+			wantArgs:        []string{"--report", "junit", "--report-path", existingDir, "--junit-report-filename", "analyze.xml"},
+			wantReportPaths: []string{filepath.Join(existingDir, "analyze.xml")},
+		},
+		{
+			name:       "xcbeautify report file name with a directory",
+			outputTool: XcbeautifyTool,
+			options:    "--report junit --junit-report-filename ../analyze.xml",
+			wantErr:    "a file name is expected",
+		},
+		{
+			name:       "xcbeautify report file name pointing to a directory",
+			outputTool: XcbeautifyTool,
+			options:    "--report junit --report-path . --junit-report-filename reports",
+			wantErr:    "is a directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, reportPaths, err := parseFormatterOptions(tt.outputTool, tt.options, workdir, "/out")
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.wantArgs, args)
+				assert.Equal(t, tt.wantReportPaths, reportPaths)
+			}
+		})
+	}
+}
+
+func TestRemoveFormatterReports(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "junit.xml")
+	reportsDir := filepath.Join(dir, "reports")
+	kept := filepath.Join(reportsDir, "kept.txt")
+	if !assert.NoError(t, os.Mkdir(reportsDir, 0755)) ||
+		!assert.NoError(t, os.WriteFile(report, []byte("<testsuites/>"), 0644)) ||
+		!assert.NoError(t, os.WriteFile(kept, nil, 0644)) {
+		return
+	}
+
+	assert.NoError(t, removeFormatterReports([]string{report, filepath.Join(dir, "missing.xml")}))
+	assert.NoFileExists(t, report)
+
+	err := removeFormatterReports([]string{reportsDir})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not a regular file")
+	}
+	assert.FileExists(t, kept)
+}
+
+func TestCollectFormatterReports(t *testing.T) {
+	dir := t.TempDir()
+	outputDir := filepath.Join(dir, "output")
+	buildDir := filepath.Join(dir, "build")
+	for _, d := range []string{outputDir, buildDir} {
+		if !assert.NoError(t, os.Mkdir(d, 0755)) {
+			return
+		}
+	}
+
+	inOutputDir := filepath.Join(outputDir, "xcpretty-report.html")
+	inBuildDir := filepath.Join(buildDir, "junit.xml")
+	sameName := filepath.Join(dir, "junit.xml")
+	for _, pth := range []string{inOutputDir, inBuildDir, sameName} {
+		if !assert.NoError(t, os.WriteFile(pth, []byte(pth), 0644)) {
+			return
+		}
+	}
+
+	// reports with the same file name are rejected before copying anything
+	_, err := collectFormatterReports([]string{inBuildDir, sameName}, outputDir)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "have the same file name")
+	}
+	assert.NoFileExists(t, filepath.Join(outputDir, "junit.xml"))
+
+	// the reports in the output directory are not copied, the missing ones are skipped
+	collected, err := collectFormatterReports([]string{inOutputDir, filepath.Join(buildDir, "missing.xml")}, outputDir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{inOutputDir}, collected)
+	}
+
+	if _, err := exec.LookPath("rsync"); err != nil {
+		t.Skip("the reports are copied with rsync, which is not installed")
+	}
+	collected, err = collectFormatterReports([]string{inOutputDir, inBuildDir}, outputDir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{inOutputDir, filepath.Join(outputDir, "junit.xml")}, collected)
+		content, err := os.ReadFile(filepath.Join(outputDir, "junit.xml"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, inBuildDir, string(content))
+		}
+	}
+}
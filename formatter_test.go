@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/v2/xcodecommand"
+	version "github.com/hashicorp/go-version"
+	"github.com/stretchr/testify/assert"
+)
+
+// installedVersionRunner is a runner with a fixed installed log formatter version.
+type installedVersionRunner struct {
+	xcodecommand.Runner
+	installed *version.Version
+}
+
+func (r installedVersionRunner) CheckInstall() (*version.Version, error) {
+	return r.installed, nil
+}
+
+func TestPinnedVersionRunnerCheckInstall(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed string
+		pinned    string
+		wantErr   string
+	}{
+		{name: "installed version matches the pinned one", installed: "2.1.0", pinned: "2.1.0"},
+		{name: "installed version differs from the pinned one", installed: "2.2.0", pinned: "2.1.0", wantErr: "the Step does not install xcbeautify"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &pinnedVersionRunner{
+				Runner:        installedVersionRunner{installed: version.Must(version.NewVersion(tt.installed))},
+				outputTool:    XcbeautifyTool,
+				pinnedVersion: version.Must(version.NewVersion(tt.pinned)),
+			}
+
+			got, err := runner.CheckInstall()
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.installed, got.String())
+			}
+		})
+	}
+}
+
+func TestOutputToolChain(t *testing.T) {
+	tests := []struct {
+		name       string
+		outputTool string
+		fallbacks  string
+		want       []string
+		wantErr    bool
+	}{
+		{name: "xcodebuild is added as the last resort", outputTool: XcbeautifyTool, want: []string{XcbeautifyTool, XcodebuildTool}},
+		{name: "fallbacks in order", outputTool: XcbeautifyTool, fallbacks: "xcpretty, xcodebuild", want: []string{XcbeautifyTool, XcprettyTool, XcodebuildTool}},
+		{name: "duplicates are removed", outputTool: XcprettyTool, fallbacks: "xcpretty,xcbeautify,xcpretty", want: []string{XcprettyTool, XcbeautifyTool, XcodebuildTool}},
+		{name: "xcodebuild output tool", outputTool: XcodebuildTool, fallbacks: "xcpretty", want: []string{XcodebuildTool, XcprettyTool}},
+		{name: "unknown fallback", outputTool: XcprettyTool, fallbacks: "xcfancy", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := outputToolChain(tt.outputTool, tt.fallbacks)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDebugLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		debugLog  bool
+		wantInfo  bool
+		wantWarns bool
+	}{
+		{name: "without verbose logging only the warnings are printed", debugLog: false, wantInfo: false, wantWarns: true},
+		{name: "with verbose logging everything is printed", debugLog: true, wantInfo: true, wantWarns: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			logger := newDebugLogger(log.NewLogger(log.WithOutput(&out), log.WithDebugLog(tt.debugLog)))
+
+			logger.Println()
+			logger.Infof("Checking log formatter (xcbeautify) version")
+			logger.Printf("Installing xcpretty")
+			logger.Donef("installed")
+			logger.Warnf("xcpretty is not installed")
+
+			assert.Equal(t, tt.wantInfo, bytes.Contains(out.Bytes(), []byte("Checking log formatter")))
+			assert.Equal(t, tt.wantInfo, bytes.Contains(out.Bytes(), []byte("Installing xcpretty")))
+			assert.Equal(t, tt.wantWarns, bytes.Contains(out.Bytes(), []byte("xcpretty is not installed")))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+const (
+	packageAuthorizationProviderFlag = "-packageAuthorizationProvider"
+	scmProviderFlag                  = "-scmProvider"
+
+	// defaultPackageCredentialLogin is used when a credential has no login, Git hosts accept any login with an access token.
+	defaultPackageCredentialLogin = "oauth2"
+)
+
+// packageCredential is an access token for a private Git host or package registry.
+type packageCredential struct {
+	Host  string
+	Login string
+	Token string
+}
+
+// parsePackageCredentials parses the newline separated `<host> <token>` or `<host> <login> <token>` entries.
+func parsePackageCredentials(input string) ([]packageCredential, error) {
+	var credentials []packageCredential
+	for i, line := range strings.Split(input, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		credential := packageCredential{Login: defaultPackageCredentialLogin}
+		switch len(fields) {
+		case 2:
+			credential.Host, credential.Token = fields[0], fields[1]
+		case 3:
+			credential.Host, credential.Login, credential.Token = fields[0], fields[1], fields[2]
+		default:
+			// the line is not printed, as it contains the token
+			return nil, fmt.Errorf("invalid package credential in line %d: expected `<host> <token>` or `<host> <login> <token>`", i+1)
+		}
+
+		if strings.Contains(credential.Host, "/") {
+			return nil, fmt.Errorf("invalid package credential host in line %d (%s): only the host name is expected, without scheme and path", i+1, credential.Host)
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, nil
+}
+
+// netrcContent returns the .netrc entries of the credentials.
+func netrcContent(credentials []packageCredential) string {
+	var b strings.Builder
+	for _, credential := range credentials {
+		fmt.Fprintf(&b, "machine %s login %s password %s\n", credential.Host, credential.Login, credential.Token)
+	}
+	return b.String()
+}
+
+// gitConfigContent returns a git config which rewrites the SSH URLs of the credentials' hosts to HTTPS,
+// so that the .netrc credentials are used for them. The user's global config is included.
+func gitConfigContent(credentials []packageCredential, globalConfigPath string) string {
+	var b strings.Builder
+	if globalConfigPath != "" {
+		fmt.Fprintf(&b, "[include]\n\tpath = %s\n", globalConfigPath)
+	}
+	for _, credential := range credentials {
+		fmt.Fprintf(&b, "[url \"https://%s/\"]\n", credential.Host)
+		fmt.Fprintf(&b, "\tinsteadOf = git@%s:\n", credential.Host)
+		fmt.Fprintf(&b, "\tinsteadOf = ssh://git@%s/\n", credential.Host)
+	}
+	return b.String()
+}
+
+// preparePackageAuthentication writes the .netrc (used by xcodebuild with the netrc authorization provider) and the git config
+// with the URL rewrites into a temporary home directory, and returns the environment variables pointing xcodebuild and git to it.
+// The other entries of the user's home directory (for example ~/Library, ~/.swiftpm and ~/.ssh) are linked into the temporary home,
+// so that xcodebuild and git find them; the user's .netrc is never modified.
+// The returned function removes the temporary home, it is non-nil even if an error is returned.
+func preparePackageAuthentication(credentials []packageCredential) ([]string, func() error, error) {
+	cleanup := func() error { return nil }
+
+	homeDir := pathutil.UserHomeDir()
+	globalConfigPath := filepath.Join(homeDir, ".gitconfig")
+	if pth := os.Getenv("GIT_CONFIG_GLOBAL"); pth != "" {
+		globalConfigPath = pth
+	}
+	if _, err := os.Stat(globalConfigPath); err != nil {
+		globalConfigPath = ""
+	}
+
+	tmpHome, err := os.MkdirTemp("", "package_auth")
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("failed to create temporary home directory, error: %s", err)
+	}
+	// os.RemoveAll removes the symlinks, not the linked entries of the user's home directory
+	cleanup = func() error { return os.RemoveAll(tmpHome) }
+
+	entries, err := os.ReadDir(homeDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, cleanup, fmt.Errorf("failed to list the home directory (%s), error: %s", homeDir, err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".netrc" || entry.Name() == ".gitconfig" {
+			continue
+		}
+		pth := filepath.Join(homeDir, entry.Name())
+		if err := os.Symlink(pth, filepath.Join(tmpHome, entry.Name())); err != nil {
+			return nil, cleanup, fmt.Errorf("failed to link %s into the temporary home directory, error: %s", pth, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpHome, ".netrc"), []byte(netrcContent(credentials)), 0600); err != nil {
+		return nil, cleanup, fmt.Errorf("failed to write the .netrc, error: %s", err)
+	}
+
+	gitConfigPath := filepath.Join(tmpHome, ".gitconfig")
+	if err := os.WriteFile(gitConfigPath, []byte(gitConfigContent(credentials, globalConfigPath)), 0600); err != nil {
+		return nil, cleanup, fmt.Errorf("failed to write the git config, error: %s", err)
+	}
+
+	return []string{"HOME=" + tmpHome, "GIT_CONFIG_GLOBAL=" + gitConfigPath}, cleanup, nil
+}
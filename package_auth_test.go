@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePackageCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []packageCredential
+		wantErr string
+	}{
+		{name: "empty input", input: "\n  \n"},
+		{
+			name:  "default login",
+			input: "github.com ghp_token",
+			want:  []packageCredential{{Host: "github.com", Login: defaultPackageCredentialLogin, Token: "ghp_token"}},
+		},
+		{
+			name:  "multiple entries with login",
+			input: "github.com ghp_token\n\n  gitlab.example.com ci glpat_token  \n",
+			want: []packageCredential{
+				{Host: "github.com", Login: defaultPackageCredentialLogin, Token: "ghp_token"},
+				{Host: "gitlab.example.com", Login: "ci", Token: "glpat_token"},
+			},
+		},
+		{name: "missing token", input: "github.com", wantErr: "line 1"},
+		{name: "the invalid line is not printed", input: "github.com ghp_token\na b c secret", wantErr: "line 2"},
+		{name: "host with scheme", input: "https://github.com ghp_token", wantErr: "only the host name is expected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePackageCredentials(tt.input)
+			if tt.wantErr != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.wantErr)
+					assert.NotContains(t, err.Error(), "secret")
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+var testPackageCredentials = []packageCredential{
+	{Host: "github.com", Login: defaultPackageCredentialLogin, Token: "ghp_token"},
+	{Host: "packages.example.com", Login: "ci", Token: "registry_token"},
+}
+
+func TestNetrcContent(t *testing.T) {
+	want := "machine github.com login oauth2 password ghp_token\n" +
+		"machine packages.example.com login ci password registry_token\n"
+	assert.Equal(t, want, netrcContent(testPackageCredentials))
+}
+
+func TestGitConfigContent(t *testing.T) {
+	rewrites := "[url \"https://github.com/\"]\n" +
+		"\tinsteadOf = git@github.com:\n" +
+		"\tinsteadOf = ssh://git@github.com/\n" +
+		"[url \"https://packages.example.com/\"]\n" +
+		"\tinsteadOf = git@packages.example.com:\n" +
+		"\tinsteadOf = ssh://git@packages.example.com/\n"
+
+	assert.Equal(t, rewrites, gitConfigContent(testPackageCredentials, ""))
+	assert.Equal(t, "[include]\n\tpath = /Users/vagrant/.gitconfig\n"+rewrites, gitConfigContent(testPackageCredentials, "/Users/vagrant/.gitconfig"))
+}
+
+func TestPreparePackageAuthentication(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+	t.Setenv("GIT_CONFIG_GLOBAL", "")
+
+	originalNetrc := "machine example.com login user password original\n"
+	globalConfigPath := filepath.Join(homeDir, ".gitconfig")
+	libraryDir := filepath.Join(homeDir, "Library")
+	swiftPMDir := filepath.Join(homeDir, ".swiftpm")
+	for pth, content := range map[string]string{
+		filepath.Join(homeDir, ".netrc"):                              originalNetrc,
+		globalConfigPath:                                              "[user]\n\tname = CI\n",
+		filepath.Join(libraryDir, "Developer", "placeholder"):         "",
+		filepath.Join(swiftPMDir, "configuration", "registries.json"): "{}",
+	} {
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755)) || !assert.NoError(t, os.WriteFile(pth, []byte(content), 0600)) {
+			return
+		}
+	}
+
+	env, cleanup, err := preparePackageAuthentication(testPackageCredentials)
+	if !assert.NoError(t, err) {
+		_ = cleanup()
+		return
+	}
+
+	defer func() { _ = cleanup() }()
+	if !assert.Len(t, env, 2) {
+		return
+	}
+	tmpHome := strings.TrimPrefix(env[0], "HOME=")
+	assert.Equal(t, "GIT_CONFIG_GLOBAL="+filepath.Join(tmpHome, ".gitconfig"), env[1])
+
+	netrc, err := os.ReadFile(filepath.Join(tmpHome, ".netrc"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, netrcContent(testPackageCredentials), string(netrc))
+	}
+	if info, err := os.Stat(filepath.Join(tmpHome, ".netrc")); assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	gitConfig, err := os.ReadFile(filepath.Join(tmpHome, ".gitconfig"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, gitConfigContent(testPackageCredentials, globalConfigPath), string(gitConfig))
+	}
+	// the other entries of the user's home directory are linked
+	for _, dir := range []string{libraryDir, swiftPMDir} {
+		link, err := os.Readlink(filepath.Join(tmpHome, filepath.Base(dir)))
+		if assert.NoError(t, err) {
+			assert.Equal(t, dir, link)
+		}
+	}
+
+	// the user's .netrc is not modified
+	netrc, err = os.ReadFile(filepath.Join(homeDir, ".netrc"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, originalNetrc, string(netrc))
+	}
+
+	assert.NoError(t, cleanup())
+	assert.NoDirExists(t, tmpHome)
+	assert.FileExists(t, filepath.Join(libraryDir, "Developer", "placeholder"))
+	assert.FileExists(t, filepath.Join(swiftPMDir, "configuration", "registries.json"))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/cache"
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	cacheLevelSwiftPackages      = "swift_packages"
+	cacheLevelSwiftPackagesKeyed = "swift_packages_keyed"
+
+	swiftPackagesCacheKeyPrefix = "xcode-analyze-swift-packages-"
+	// swiftPackagesCacheKeyFilename stores the cache key in the cached directory,
+	// so that the key of a restored cache is known.
+	swiftPackagesCacheKeyFilename = ".bitrise-cache-key"
+
+	swiftPackagesCacheDescriptorFilename = "swift-packages-cache.json"
+
+	swiftPackagesCacheDescriptorPathEnvKey = "BITRISE_SWIFT_PACKAGES_CACHE_DESCRIPTOR_PATH"
+	swiftPackagesCacheKeyEnvKey            = "BITRISE_SWIFT_PACKAGES_CACHE_KEY"
+	swiftPackagesCachePathsEnvKey          = "BITRISE_SWIFT_PACKAGES_CACHE_PATHS"
+	swiftPackagesCacheSaveEnvKey           = "BITRISE_SWIFT_PACKAGES_CACHE_SAVE"
+)
+
+// collectSwiftPackages marks the Swift package cache directory to be added to the (path based) cache.
+// It is the same as github.com/bitrise-io/go-xcode/xcodecache.CollectSwiftPackages, but for a custom DerivedData path.
+func collectSwiftPackages(swiftPackagesPath string) error {
+	c := cache.New()
+	c.IncludePath(swiftPackagesPath)
+	// Excluding manifest.db will result in a stable cache, as this file is modified in every build.
+	c.ExcludePath("!" + filepath.Join(swiftPackagesPath, "manifest.db"))
+
+	if err := c.Commit(); err != nil {
+		return fmt.Errorf("failed to commit cache, error: %s", err)
+	}
+	return nil
+}
+
+// swiftPackagesCacheDescriptor describes the Swift package cache for the key-based cache restore and save steps.
+type swiftPackagesCacheDescriptor struct {
+	Key   string   `json:"key"`
+	Paths []string `json:"paths"`
+	// Save is false if the restored cache has the same key, so saving it would not change it.
+	Save bool `json:"save"`
+}
+
+// swiftPackagesCacheKey returns the cache key computed from the Package.resolved content, the Xcode version (`xcodebuild -version`) and the project path.
+func swiftPackagesCacheKey(packageResolvedPath, projectPath, xcodeVersion string) (string, error) {
+	resolved, err := os.ReadFile(packageResolvedPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Package.resolved: %w", err)
+	}
+
+	projectHash, err := derivedDataHash(projectPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get project path hash: %w", err)
+	}
+
+	hash := sha256.New()
+	for _, part := range [][]byte{resolved, []byte(xcodeVersion), []byte(projectHash)} {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
+
+	return swiftPackagesCacheKeyPrefix + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// newSwiftPackagesCacheDescriptor creates the descriptor of the Swift package cache directory,
+// and stores the key in the directory, so that it is cached with the packages.
+func newSwiftPackagesCacheDescriptor(key, swiftPackagesPath string) (swiftPackagesCacheDescriptor, error) {
+	descriptor := swiftPackagesCacheDescriptor{
+		Key:   key,
+		Paths: []string{swiftPackagesPath},
+		Save:  true,
+	}
+
+	keyPath := filepath.Join(swiftPackagesPath, swiftPackagesCacheKeyFilename)
+	restoredKey, err := os.ReadFile(keyPath)
+	if err != nil && !os.IsNotExist(err) {
+		return descriptor, fmt.Errorf("failed to read restored cache key: %w", err)
+	}
+	if string(restoredKey) == key {
+		descriptor.Save = false
+		return descriptor, nil
+	}
+
+	if err := os.WriteFile(keyPath, []byte(key), 0644); err != nil {
+		return descriptor, fmt.Errorf("failed to write cache key: %w", err)
+	}
+	return descriptor, nil
+}
+
+// writeSwiftPackagesCacheDescriptor writes the descriptor as JSON to the output directory, and returns its path.
+func writeSwiftPackagesCacheDescriptor(descriptor swiftPackagesCacheDescriptor, outputDir string) (string, error) {
+	content, err := json.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cache descriptor: %w", err)
+	}
+
+	pth := filepath.Join(outputDir, swiftPackagesCacheDescriptorFilename)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write cache descriptor (%s): %w", pth, err)
+	}
+	return pth, nil
+}
+
+// prepareKeyedSwiftPackagesCache computes the key of the resolved Swift packages, and stores it in the Swift package cache directory.
+// It runs right after the package resolution, so that the stored key belongs to the resolved packages even if a later phase fails.
+// It returns nil if there are no packages to cache. The Xcode version is read with the runner, under its watchdog.
+func prepareKeyedSwiftPackagesCache(projectPath, swiftPackagesPath, workDir string, runner *xcodebuildRunner, logger log.Logger) (*swiftPackagesCacheDescriptor, error) {
+	if _, err := os.Stat(swiftPackagesPath); os.IsNotExist(err) {
+		logger.Printf("No Swift packages found at %s, nothing to cache", swiftPackagesPath)
+		return nil, nil
+	}
+
+	resolvedPath, err := packageResolvedPath(projectPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check Package.resolved: %w", err)
+	}
+	if resolvedPath == "" {
+		return nil, fmt.Errorf("Package.resolved not found in %s, it is required for the cache key", projectPath)
+	}
+
+	xcodeVersion, err := runner.Output(workDir, []string{"-version"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Xcode version: %w", err)
+	}
+
+	key, err := swiftPackagesCacheKey(resolvedPath, projectPath, xcodeVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor, err := newSwiftPackagesCacheDescriptor(key, swiftPackagesPath)
+	if err != nil {
+		return nil, err
+	}
+	if descriptor.Save {
+		logger.Printf("Swift packages cache key: %s", key)
+	} else {
+		logger.Printf("Swift packages cache key did not change (%s), the cache does not need to be saved", key)
+	}
+	return &descriptor, nil
+}
+
+// exportSwiftPackagesCacheDescriptor writes the key-based Swift package cache descriptor, and exports its values.
+func exportSwiftPackagesCacheDescriptor(descriptor swiftPackagesCacheDescriptor, outputDir string, logger log.Logger) error {
+	descriptorPath, err := writeSwiftPackagesCacheDescriptor(descriptor, outputDir)
+	if err != nil {
+		return err
+	}
+
+	for _, env := range [][2]string{
+		{swiftPackagesCacheDescriptorPathEnvKey, descriptorPath},
+		{swiftPackagesCacheKeyEnvKey, descriptor.Key},
+		{swiftPackagesCachePathsEnvKey, strings.Join(descriptor.Paths, "\n")},
+		{swiftPackagesCacheSaveEnvKey, strconv.FormatBool(descriptor.Save)},
+	} {
+		if err := tools.ExportEnvironmentWithEnvman(env[0], env[1]); err != nil {
+			return fmt.Errorf("failed to export %s: %w", env[0], err)
+		}
+		logger.Printf("Exported %s: %s", env[0], env[1])
+	}
+
+	return nil
+}
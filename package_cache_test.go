@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwiftPackagesCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	resolvedPath := filepath.Join(dir, "Package.resolved")
+	otherResolvedPath := filepath.Join(dir, "Other.resolved")
+	if !assert.NoError(t, os.WriteFile(resolvedPath, []byte(`{"pins": []}`), 0644)) ||
+		!assert.NoError(t, os.WriteFile(otherResolvedPath, []byte(`{"pins": [{"identity": "a"}]}`), 0644)) {
+		return
+	}
+	projectPath := filepath.Join(dir, "App.xcodeproj")
+	xcodeVersion := "Xcode 15.4\nBuild version 15F31d"
+
+	key, err := swiftPackagesCacheKey(resolvedPath, projectPath, xcodeVersion)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(key, swiftPackagesCacheKeyPrefix))
+
+	sameKey, err := swiftPackagesCacheKey(resolvedPath, projectPath, xcodeVersion)
+	if assert.NoError(t, err) {
+		assert.Equal(t, key, sameKey)
+	}
+
+	tests := []struct {
+		name                string
+		packageResolvedPath string
+		projectPath         string
+		xcodeVersion        string
+	}{
+		{name: "Package.resolved changed", packageResolvedPath: otherResolvedPath, projectPath: projectPath, xcodeVersion: xcodeVersion},
+		{name: "Xcode version changed", packageResolvedPath: resolvedPath, projectPath: projectPath, xcodeVersion: "Xcode 16.0\nBuild version 16A242d"},
+		{name: "project path changed", packageResolvedPath: resolvedPath, projectPath: filepath.Join(dir, "Other.xcodeproj"), xcodeVersion: xcodeVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otherKey, err := swiftPackagesCacheKey(tt.packageResolvedPath, tt.projectPath, tt.xcodeVersion)
+			if assert.NoError(t, err) {
+				assert.NotEqual(t, key, otherKey)
+			}
+		})
+	}
+
+	_, err = swiftPackagesCacheKey(filepath.Join(dir, "missing.resolved"), projectPath, xcodeVersion)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "failed to read Package.resolved")
+	}
+}
+
+func TestNewSwiftPackagesCacheDescriptor(t *testing.T) {
+	swiftPackagesPath := t.TempDir()
+	keyPath := filepath.Join(swiftPackagesPath, swiftPackagesCacheKeyFilename)
+
+	// no restored cache: the key is stored and the cache is saved
+	descriptor, err := newSwiftPackagesCacheDescriptor("key-1", swiftPackagesPath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, swiftPackagesCacheDescriptor{Key: "key-1", Paths: []string{swiftPackagesPath}, Save: true}, descriptor)
+	}
+	content, err := os.ReadFile(keyPath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "key-1", string(content))
+	}
+
+	// the restored cache has the same key: it does not need to be saved
+	descriptor, err = newSwiftPackagesCacheDescriptor("key-1", swiftPackagesPath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, swiftPackagesCacheDescriptor{Key: "key-1", Paths: []string{swiftPackagesPath}, Save: false}, descriptor)
+	}
+
+	// the restored cache has a different key: the new key is stored and the cache is saved
+	descriptor, err = newSwiftPackagesCacheDescriptor("key-2", swiftPackagesPath)
+	if assert.NoError(t, err) {
+		assert.True(t, descriptor.Save)
+	}
+	content, err = os.ReadFile(keyPath)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "key-2", string(content))
+	}
+}
+
+func TestWriteSwiftPackagesCacheDescriptor(t *testing.T) {
+	outputDir := t.TempDir()
+	descriptor := swiftPackagesCacheDescriptor{Key: "key", Paths: []string{"/derived-data/SourcePackages"}, Save: false}
+
+	pth, err := writeSwiftPackagesCacheDescriptor(descriptor, outputDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, filepath.Join(outputDir, swiftPackagesCacheDescriptorFilename), pth)
+
+	content, err := os.ReadFile(pth)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var written swiftPackagesCacheDescriptor
+	if assert.NoError(t, json.Unmarshal(content, &written)) {
+		assert.Equal(t, descriptor, written)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const onlyUsePackageVersionsFromResolvedFileFlag = "-onlyUsePackageVersionsFromResolvedFile"
+
+// packagePin is a Swift package version pinned in Package.resolved.
+type packagePin struct {
+	Identity string
+	Location string
+	Revision string
+	Version  string
+	Branch   string
+}
+
+// checkoutName returns the package's directory name in SourcePackages/checkouts, which is the last component of the repository URL.
+func (p packagePin) checkoutName() string {
+	name := strings.TrimSuffix(strings.TrimRight(p.Location, "/"), ".git")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+func (p packagePin) String() string {
+	switch {
+	case p.Version != "":
+		return fmt.Sprintf("%s (%s)", p.Revision, p.Version)
+	case p.Branch != "":
+		return fmt.Sprintf("%s (branch %s)", p.Revision, p.Branch)
+	default:
+		return p.Revision
+	}
+}
+
+// packageResolvedPath returns the path of the Package.resolved file of an xcodeproj or xcworkspace,
+// or an empty string if it does not exist.
+func packageResolvedPath(projectPath string) (string, error) {
+	sharedDataDir := filepath.Join(projectPath, "xcshareddata")
+	if filepath.Ext(projectPath) == ".xcodeproj" {
+		sharedDataDir = filepath.Join(projectPath, "project.xcworkspace", "xcshareddata")
+	}
+
+	pth := filepath.Join(sharedDataDir, "swiftpm", "Package.resolved")
+	if _, err := os.Stat(pth); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return pth, nil
+}
+
+// parsePackageResolved parses the pins of a Package.resolved file (version 1, 2 and 3 formats).
+func parsePackageResolved(pth string) ([]packagePin, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	type state struct {
+		Revision string `json:"revision"`
+		Version  string `json:"version"`
+		Branch   string `json:"branch"`
+	}
+	var resolved struct {
+		Version int `json:"version"`
+		// version 1
+		Object struct {
+			Pins []struct {
+				Package       string `json:"package"`
+				RepositoryURL string `json:"repositoryURL"`
+				State         state  `json:"state"`
+			} `json:"pins"`
+		} `json:"object"`
+		// version 2 and 3
+		Pins []struct {
+			Identity string `json:"identity"`
+			Location string `json:"location"`
+			State    state  `json:"state"`
+		} `json:"pins"`
+	}
+	if err := json.Unmarshal(content, &resolved); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", pth, err)
+	}
+
+	var pins []packagePin
+	if resolved.Version == 1 {
+		for _, pin := range resolved.Object.Pins {
+			pins = append(pins, packagePin{Identity: pin.Package, Location: pin.RepositoryURL, Revision: pin.State.Revision, Version: pin.State.Version, Branch: pin.State.Branch})
+		}
+	} else {
+		for _, pin := range resolved.Pins {
+			pins = append(pins, packagePin{Identity: pin.Identity, Location: pin.Location, Revision: pin.State.Revision, Version: pin.State.Version, Branch: pin.State.Branch})
+		}
+	}
+	return pins, nil
+}
+
+// sourcePackagesDir returns the Swift package cache (SourcePackages) directory used by xcodebuild with the given options:
+// the -clonedSourcePackagesDirPath (resolved against the working directory), or the SourcePackages directory of the DerivedData.
+func sourcePackagesDir(customOptions []string, derivedDataSourcePackagesDir, workdir string) string {
+	for i := 0; i+1 < len(customOptions); i++ {
+		if customOptions[i] == "-clonedSourcePackagesDirPath" {
+			return resolvePath(workdir, customOptions[i+1])
+		}
+	}
+	return derivedDataSourcePackagesDir
+}
+
+// packageCheckoutMismatch is a cached package checkout which differs from its pinned version.
+type packageCheckoutMismatch struct {
+	pin            packagePin
+	cachedRevision string
+}
+
+// findPackageCheckoutMismatches compares the revisions of the cached package checkouts with the pinned ones.
+// Packages without a cached checkout are not mismatches, as they are checked out at the pinned version.
+func findPackageCheckoutMismatches(pins []packagePin, checkoutsDir string, cmdFactory command.Factory) ([]packageCheckoutMismatch, error) {
+	var mismatches []packageCheckoutMismatch
+	for _, pin := range pins {
+		checkoutDir := filepath.Join(checkoutsDir, pin.checkoutName())
+		if _, err := os.Stat(checkoutDir); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		cmd := cmdFactory.Create("git", []string{"-C", checkoutDir, "rev-parse", "HEAD"}, nil)
+		revision, err := cmd.RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the revision of the %s package checkout: %s: %w", pin.Identity, revision, err)
+		}
+
+		if revision != pin.Revision {
+			mismatches = append(mismatches, packageCheckoutMismatch{pin: pin, cachedRevision: revision})
+		}
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].pin.Identity < mismatches[j].pin.Identity
+	})
+	return mismatches, nil
+}
+
+// packageCheckoutMismatchDiff returns a human readable diff of the mismatching packages.
+func packageCheckoutMismatchDiff(mismatches []packageCheckoutMismatch) string {
+	var lines []string
+	for _, mismatch := range mismatches {
+		lines = append(lines,
+			fmt.Sprintf("%s:", mismatch.pin.Identity),
+			fmt.Sprintf("- pinned: %s", mismatch.pin),
+			fmt.Sprintf("+ cached: %s", mismatch.cachedRevision),
+		)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkPinnedPackageVersions fails if the project has no Package.resolved file,
+// or if the cached package checkouts differ from the versions pinned in it.
+func checkPinnedPackageVersions(projectPath, sourcePackagesDir string, cmdFactory command.Factory, logger log.Logger) error {
+	resolvedPath, err := packageResolvedPath(projectPath)
+	if err != nil {
+		return fmt.Errorf("failed to check Package.resolved: %w", err)
+	}
+	if resolvedPath == "" {
+		return fmt.Errorf("Package.resolved not found in %s, it is required to use the pinned package versions", projectPath)
+	}
+
+	pins, err := parsePackageResolved(resolvedPath)
+	if err != nil {
+		return err
+	}
+	logger.Printf("%d packages pinned in %s", len(pins), resolvedPath)
+
+	checkoutsDir := filepath.Join(sourcePackagesDir, "checkouts")
+	if _, err := os.Stat(checkoutsDir); os.IsNotExist(err) {
+		logger.Printf("No cached package checkouts found at %s", checkoutsDir)
+		return nil
+	}
+
+	mismatches, err := findPackageCheckoutMismatches(pins, checkoutsDir, cmdFactory)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d cached package checkouts (%s) differ from the versions pinned in Package.resolved:\n%s", len(mismatches), checkoutsDir, packageCheckoutMismatchDiff(mismatches))
+	}
+
+	logger.Printf("The cached package checkouts match the pinned versions")
+	return nil
+}
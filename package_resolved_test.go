@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const packageResolvedV1 = `{
+  "object": {
+    "pins": [
+      {
+        "package": "Alamofire",
+        "repositoryURL": "https://github.com/Alamofire/Alamofire.git",
+        "state": {
+          "branch": null,
+          "revision": "f96b619bcb2383b43d898402283924b80e2c4bae",
+          "version": "5.4.3"
+        }
+      }
+    ]
+  },
+  "version": 1
+}`
+
+const packageResolvedV2 = `{
+  "pins" : [
+    {
+      "identity" : "alamofire",
+      "kind" : "remoteSourceControl",
+      "location" : "https://github.com/Alamofire/Alamofire.git",
+      "state" : {
+        "revision" : "f96b619bcb2383b43d898402283924b80e2c4bae",
+        "version" : "5.4.3"
+      }
+    },
+    {
+      "identity" : "internal-kit",
+      "kind" : "remoteSourceControl",
+      "location" : "git@git.example.com:ios/InternalKit",
+      "state" : {
+        "branch" : "main",
+        "revision" : "0c1e2d3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d"
+      }
+    }
+  ],
+  "version" : 2
+}`
+
+func TestParsePackageResolved(t *testing.T) {
+	alamofire := packagePin{Location: "https://github.com/Alamofire/Alamofire.git", Revision: "f96b619bcb2383b43d898402283924b80e2c4bae", Version: "5.4.3"}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []packagePin
+		wantErr bool
+	}{
+		{
+			name:    "version 1",
+			content: packageResolvedV1,
+			want:    []packagePin{{Identity: "Alamofire", Location: alamofire.Location, Revision: alamofire.Revision, Version: alamofire.Version}},
+		},
+		{
+			name:    "version 2",
+			content: packageResolvedV2,
+			want: []packagePin{
+				{Identity: "alamofire", Location: alamofire.Location, Revision: alamofire.Revision, Version: alamofire.Version},
+				{Identity: "internal-kit", Location: "git@git.example.com:ios/InternalKit", Revision: "0c1e2d3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d", Branch: "main"},
+			},
+		},
+		{name: "no pins", content: `{"pins": [], "version": 3}`},
+		{name: "invalid JSON", content: `{"pins": [`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pth := filepath.Join(t.TempDir(), "Package.resolved")
+			if !assert.NoError(t, os.WriteFile(pth, []byte(tt.content), 0644)) {
+				return
+			}
+
+			got, err := parsePackageResolved(pth)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPackageResolvedPath(t *testing.T) {
+	dir := t.TempDir()
+	projectPath := filepath.Join(dir, "App.xcodeproj")
+	workspacePath := filepath.Join(dir, "App.xcworkspace")
+	projectResolved := filepath.Join(projectPath, "project.xcworkspace", "xcshareddata", "swiftpm", "Package.resolved")
+	workspaceResolved := filepath.Join(workspacePath, "xcshareddata", "swiftpm", "Package.resolved")
+	for _, pth := range []string{projectResolved, workspaceResolved} {
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755)) || !assert.NoError(t, os.WriteFile(pth, []byte(packageResolvedV2), 0644)) {
+			return
+		}
+	}
+
+	for pth, want := range map[string]string{
+		projectPath:                           projectResolved,
+		workspacePath:                         workspaceResolved,
+		filepath.Join(dir, "Other.xcodeproj"): "",
+	} {
+		got, err := packageResolvedPath(pth)
+		if assert.NoError(t, err) {
+			assert.Equal(t, want, got)
+		}
+	}
+}
+
+func TestPackagePinCheckoutName(t *testing.T) {
+	tests := map[string]string{
+		"https://github.com/Alamofire/Alamofire.git":     "Alamofire",
+		"https://github.com/pointfreeco/swift-nonempty/": "swift-nonempty",
+		"git@git.example.com:InternalKit.git":            "InternalKit",
+		"ssh://git@git.example.com/ios/InternalKit":      "InternalKit",
+	}
+	for location, want := range tests {
+		assert.Equal(t, want, packagePin{Location: location}.checkoutName(), location)
+	}
+}
+
+func TestSourcePackagesDir(t *testing.T) {
+	tests := []struct {
+		name          string
+		customOptions []string
+		want          string
+	}{
+		{name: "DerivedData", customOptions: []string{"-derivedDataPath", "/tmp/dd"}, want: "/dd/App-abc/SourcePackages"},
+		{name: "cloned source packages path takes precedence", customOptions: []string{"-clonedSourcePackagesDirPath", "/tmp/spm", "-derivedDataPath", "/tmp/dd"}, want: "/tmp/spm"},
+		{name: "relative cloned source packages path", customOptions: []string{"-clonedSourcePackagesDirPath", "spm"}, want: "/src/spm"},
+		{name: "option without value", customOptions: []string{"-clonedSourcePackagesDirPath"}, want: "/dd/App-abc/SourcePackages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sourcePackagesDir(tt.customOptions, "/dd/App-abc/SourcePackages", "/src"))
+		})
+	}
+}
+
+func TestPackageCheckoutMismatchDiff(t *testing.T) {
+	mismatches := []packageCheckoutMismatch{
+		{pin: packagePin{Identity: "alamofire", Revision: "f96b619", Version: "5.4.3"}, cachedRevision: "a1b2c3d"},
+		{pin: packagePin{Identity: "internal-kit", Revision: "0c1e2d3", Branch: "main"}, cachedRevision: "9f8e7d6"},
+	}
+
+	want := "alamofire:\n- pinned: f96b619 (5.4.3)\n+ cached: a1b2c3d\n" +
+		"internal-kit:\n- pinned: 0c1e2d3 (branch main)\n+ cached: 9f8e7d6"
+	assert.Equal(t, want, packageCheckoutMismatchDiff(mismatches))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const disableAutomaticPackageResolutionFlag = "-disableAutomaticPackageResolution"
+
+// packageResolutionOptions are the xcodebuild options affecting the Swift package resolution,
+// mapped to whether they take a value. These options are passed to the package resolution phase too.
+var packageResolutionOptions = map[string]bool{
+	"-derivedDataPath":                              true,
+	"-clonedSourcePackagesDirPath":                  true,
+	"-packageCachePath":                             true,
+	"-scmProvider":                                  true,
+	"-defaultPackageRegistryURL":                    true,
+	"-packageDependencySCMToRegistryTransformation": true,
+	"-onlyUsePackageVersionsFromResolvedFile":       false,
+	"-disablePackageRepositoryCache":                false,
+	"-skipPackagePluginValidation":                  false,
+	"-skipMacroValidation":                          false,
+	"-packageAuthorizationProvider":                 true,
+}
+
+// packageResolutionError is returned when the Swift package resolution phase fails.
+type packageResolutionError struct {
+	err error
+	// failure is the transient failure class of the last attempt, if it is known.
+	failure *transientFailure
+}
+
+func (e *packageResolutionError) Error() string {
+	if e.failure != nil {
+		return fmt.Sprintf("Swift package resolution failed (%s): %s", e.failure.name, e.err)
+	}
+	return fmt.Sprintf("Swift package resolution failed: %s", e.err)
+}
+
+func (e *packageResolutionError) Unwrap() error {
+	return e.err
+}
+
+func isPackageResolutionError(err error) bool {
+	var resolutionErr *packageResolutionError
+	return errors.As(err, &resolutionErr)
+}
+
+// packageResolutionArgs returns the `xcodebuild -resolvePackageDependencies` arguments.
+// The package related options of the analyze command are kept, so that the packages are resolved to the same location.
+//
+// The arguments follow github.com/bitrise-io/go-xcode/xcodebuild.ResolvePackagesCommandModel, which can't be used directly:
+// it neither exposes its arguments nor its command, and its Run prints to the standard outputs with the v1 logger.
+// The package resolution has to run through the xcodebuildRunner instead, with the log formatter, the xcodebuild log,
+// the watchdog, the package credentials' environment and the retry policy of the analysis.
+func packageResolutionArgs(projectPath, scheme string, customOptions []string) []string {
+	args := append(projectArgs(projectPath, scheme), "-resolvePackageDependencies")
+
+	for i := 0; i < len(customOptions); i++ {
+		hasValue, ok := packageResolutionOptions[customOptions[i]]
+		if !ok {
+			continue
+		}
+		args = append(args, customOptions[i])
+		if hasValue && i+1 < len(customOptions) {
+			i++
+			args = append(args, customOptions[i])
+		}
+	}
+
+	return args
+}
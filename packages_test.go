@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageResolutionArgs(t *testing.T) {
+	tests := []struct {
+		name          string
+		projectPath   string
+		scheme        string
+		customOptions []string
+		want          []string
+	}{
+		{
+			name:        "project",
+			projectPath: "/src/App.xcodeproj",
+			scheme:      "App",
+			want:        []string{"-project", "/src/App.xcodeproj", "-scheme", "App", "-resolvePackageDependencies"},
+		},
+		{
+			name:        "workspace",
+			projectPath: "/src/App.xcworkspace",
+			scheme:      "App",
+			want:        []string{"-workspace", "/src/App.xcworkspace", "-scheme", "App", "-resolvePackageDependencies"},
+		},
+		{
+			name:          "package options are kept, the other options are dropped",
+			projectPath:   "/src/App.xcodeproj",
+			scheme:        "App",
+			customOptions: []string{"-derivedDataPath", "/dd", "COMPILER_INDEX_STORE_ENABLE=NO", "-onlyUsePackageVersionsFromResolvedFile", "-xcconfig", "/src/a.xcconfig", "-scmProvider", "system"},
+			want: []string{"-project", "/src/App.xcodeproj", "-scheme", "App", "-resolvePackageDependencies",
+				"-derivedDataPath", "/dd", "-onlyUsePackageVersionsFromResolvedFile", "-scmProvider", "system"},
+		},
+		{
+			name:          "option value missing at the end",
+			projectPath:   "/src/App.xcodeproj",
+			customOptions: []string{"-clonedSourcePackagesDirPath"},
+			want:          []string{"-project", "/src/App.xcodeproj", "-resolvePackageDependencies", "-clonedSourcePackagesDirPath"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, packageResolutionArgs(tt.projectPath, tt.scheme, tt.customOptions))
+		})
+	}
+}
+
+func TestBuildSettingsArgs(t *testing.T) {
+	got := buildSettingsArgs("/src/App.xcworkspace", "App", []string{"-derivedDataPath", "/dd"})
+	assert.Equal(t, []string{"-workspace", "/src/App.xcworkspace", "-scheme", "App", "-derivedDataPath", "/dd", "-showBuildSettings"}, got)
+}
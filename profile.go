@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/bitrise-io/go-xcode/profileutil"
+)
+
+// findForcedProvisioningProfile looks up the provisioning profile by UUID in the standard Provisioning Profiles directory
+// ($HOME/Library/MobileDevice/Provisioning Profiles).
+func findForcedProvisioningProfile(uuid string) (profileutil.ProvisioningProfileInfoModel, error) {
+	info, pth, err := profileutil.FindProvisioningProfileInfo(uuid)
+	if err != nil {
+		return profileutil.ProvisioningProfileInfoModel{}, fmt.Errorf("failed to read provisioning profile (%s), error: %s", uuid, err)
+	}
+	if pth == "" {
+		return profileutil.ProvisioningProfileInfoModel{}, fmt.Errorf("provisioning profile (%s) is not installed in %s", uuid, profileutil.ProvProfileSystemDirPath)
+	}
+	return info, nil
+}
+
+// checkForcedProvisioningProfile returns an error if the profile is expired, if none of its certificates match the forced
+// code signing identity, or if none of its certificates are installed.
+// installedCertificates is nil if the installed certificates could not be listed, in this case the last check is skipped.
+func checkForcedProvisioningProfile(profile profileutil.ProvisioningProfileInfoModel, codeSignIdentity string, installedCertificates []certificateutil.CertificateInfoModel) error {
+	if err := profile.CheckValidity(); err != nil {
+		return fmt.Errorf("provisioning profile %s (%s) has expired at %s", profile.Name, profile.UUID, profile.ExpirationDate)
+	}
+
+	if codeSignIdentity != "" {
+		var matching []certificateutil.CertificateInfoModel
+		for _, certificate := range profile.DeveloperCertificates {
+			if matchesCodeSignIdentity(certificate, codeSignIdentity) {
+				matching = append(matching, certificate)
+			}
+		}
+		if len(matching) == 0 {
+			return fmt.Errorf("none of the certificates of provisioning profile %s (%s) match the forced code signing identity (%s), the profile contains: %s",
+				profile.Name, profile.UUID, codeSignIdentity, strings.Join(certificateNames(profile.DeveloperCertificates), ", "))
+		}
+	}
+
+	if installedCertificates != nil && !profile.HasInstalledCertificate(installedCertificates) {
+		return fmt.Errorf("none of the certificates of provisioning profile %s (%s) are installed in the keychain, the profile contains: %s",
+			profile.Name, profile.UUID, strings.Join(certificateNames(profile.DeveloperCertificates), ", "))
+	}
+
+	return nil
+}
+
+// matchesCodeSignIdentity reports whether the certificate matches a code signing identity given as a full name
+// (for example `iPhone Developer: Bitrise Bot (VV2J4SV8V4)`), a name prefix (for example `iPhone Developer`) or a SHA-1 fingerprint.
+func matchesCodeSignIdentity(certificate certificateutil.CertificateInfoModel, codeSignIdentity string) bool {
+	if certificate.CommonName == codeSignIdentity || strings.HasPrefix(certificate.CommonName, codeSignIdentity+":") {
+		return true
+	}
+	return certificate.SHA1Fingerprint != "" && strings.EqualFold(certificate.SHA1Fingerprint, codeSignIdentity)
+}
+
+func certificateNames(certificates []certificateutil.CertificateInfoModel) []string {
+	var names []string
+	for _, certificate := range certificates {
+		names = append(names, fmt.Sprintf("%s [%s]", certificate.CommonName, certificate.Serial))
+	}
+	return names
+}
+
+func printProvisioningProfile(profile profileutil.ProvisioningProfileInfoModel, logger log.Logger) {
+	logger.Printf("- Name: %s (%s)", profile.Name, profile.UUID)
+	logger.Printf("- Team: %s (%s)", profile.TeamName, profile.TeamID)
+	logger.Printf("- Expiry: %s", profile.ExpirationDate)
+	logger.Printf("- Certificates:")
+	for _, certificate := range profile.DeveloperCertificates {
+		logger.Printf("  - %s", certificate)
+	}
+}
+
+// validateForcedProvisioningProfile checks the forced provisioning profile against the forced identity and the installed certificates.
+func validateForcedProvisioningProfile(uuid, codeSignIdentity string, logger log.Logger) error {
+	profile, err := findForcedProvisioningProfile(uuid)
+	if err != nil {
+		return err
+	}
+	printProvisioningProfile(profile, logger)
+
+	installedCertificates, err := certificateutil.InstalledCodesigningCertificateInfos()
+	if err != nil {
+		logger.Warnf("Failed to list installed code signing certificates, skipping the installed certificate check, error: %s", err)
+		installedCertificates = nil
+	} else if installedCertificates == nil {
+		installedCertificates = []certificateutil.CertificateInfoModel{}
+	}
+
+	return checkForcedProvisioningProfile(profile, codeSignIdentity, installedCertificates)
+}
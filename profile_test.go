@@ -0,0 +1,187 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/certificateutil"
+	"github.com/fullsailor/pkcs7"
+	"github.com/stretchr/testify/assert"
+	"howett.net/plist"
+)
+
+const testProfileUUID = "c5be4123-1234-4f9d-9843-0d9be985a068"
+
+// installTestProfile writes a signed provisioning profile, containing the certificate, into the Provisioning Profiles
+// directory of a temporary HOME.
+func installTestProfile(t *testing.T, uuid string, expiry time.Time, certificate certificateutil.CertificateInfoModel) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	content, err := plist.Marshal(map[string]interface{}{
+		"AppIDName":             "Test App",
+		"Platform":              []string{"iOS"},
+		"UUID":                  uuid,
+		"Name":                  "Test Profile",
+		"TeamName":              "Test Team",
+		"TeamIdentifier":        []string{"TEAMID1234"},
+		"CreationDate":          time.Now().Add(-24 * time.Hour),
+		"ExpirationDate":        expiry,
+		"DeveloperCertificates": [][]byte{certificate.Certificate.Raw},
+		"Entitlements": map[string]interface{}{
+			"application-identifier": "TEAMID1234.io.bitrise.test",
+		},
+	}, plist.XMLFormat)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	signedData, err := pkcs7.NewSignedData(content)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if err := signedData.AddSigner(&certificate.Certificate, certificate.PrivateKey, pkcs7.SignerInfoConfig{}); !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	profile, err := signedData.Finish()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	dir := filepath.Join(home, "Library", "MobileDevice", "Provisioning Profiles")
+	if !assert.NoError(t, os.MkdirAll(dir, 0755)) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, os.WriteFile(filepath.Join(dir, uuid+".mobileprovision"), profile, 0644)) {
+		t.FailNow()
+	}
+}
+
+func newTestCertificate(t *testing.T, serial int64, commonName string) certificateutil.CertificateInfoModel {
+	t.Helper()
+
+	certificate, privateKey, err := certificateutil.GenerateTestCertificate(serial, "TEAMID1234", "Test Team", commonName, time.Now().AddDate(1, 0, 0))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return certificateutil.NewCertificateInfo(*certificate, privateKey)
+}
+
+func TestFindForcedProvisioningProfile(t *testing.T) {
+	certificate := newTestCertificate(t, 1, "iPhone Developer: Bitrise Bot (VV2J4SV8V4)")
+
+	t.Run("missing profile", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+
+		_, err := findForcedProvisioningProfile(testProfileUUID)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "is not installed")
+		}
+	})
+
+	t.Run("installed profile", func(t *testing.T) {
+		installTestProfile(t, testProfileUUID, time.Now().AddDate(0, 1, 0), certificate)
+
+		profile, err := findForcedProvisioningProfile(testProfileUUID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, testProfileUUID, profile.UUID)
+			assert.Equal(t, "Test Profile", profile.Name)
+			if assert.Len(t, profile.DeveloperCertificates, 1) {
+				assert.Equal(t, certificate.Serial, profile.DeveloperCertificates[0].Serial)
+			}
+		}
+	})
+}
+
+func TestCheckForcedProvisioningProfile(t *testing.T) {
+	certificate := newTestCertificate(t, 1, "iPhone Developer: Bitrise Bot (VV2J4SV8V4)")
+	otherCertificate := newTestCertificate(t, 2, "iPhone Distribution: Bitrise Bot (VV2J4SV8V4)")
+
+	tests := []struct {
+		name                  string
+		expiry                time.Time
+		codeSignIdentity      string
+		installedCertificates []certificateutil.CertificateInfoModel
+		wantErr               string
+	}{
+		{
+			name:                  "valid profile with full identity",
+			expiry:                time.Now().AddDate(0, 1, 0),
+			codeSignIdentity:      "iPhone Developer: Bitrise Bot (VV2J4SV8V4)",
+			installedCertificates: []certificateutil.CertificateInfoModel{certificate},
+		},
+		{
+			name:                  "valid profile with identity prefix",
+			expiry:                time.Now().AddDate(0, 1, 0),
+			codeSignIdentity:      "iPhone Developer",
+			installedCertificates: []certificateutil.CertificateInfoModel{otherCertificate, certificate},
+		},
+		{
+			name:                  "valid profile with SHA-1 fingerprint",
+			expiry:                time.Now().AddDate(0, 1, 0),
+			codeSignIdentity:      certificate.SHA1Fingerprint,
+			installedCertificates: []certificateutil.CertificateInfoModel{certificate},
+		},
+		{
+			name:                  "valid profile without forced identity",
+			expiry:                time.Now().AddDate(0, 1, 0),
+			installedCertificates: []certificateutil.CertificateInfoModel{certificate},
+		},
+		{
+			name:             "installed certificates are not checked if they could not be listed",
+			expiry:           time.Now().AddDate(0, 1, 0),
+			codeSignIdentity: "iPhone Developer",
+		},
+		{
+			name:                  "expired profile",
+			expiry:                time.Now().AddDate(0, 0, -1),
+			codeSignIdentity:      "iPhone Developer",
+			installedCertificates: []certificateutil.CertificateInfoModel{certificate},
+			wantErr:               "has expired",
+		},
+		{
+			name:                  "certificate mismatch",
+			expiry:                time.Now().AddDate(0, 1, 0),
+			codeSignIdentity:      "iPhone Distribution",
+			installedCertificates: []certificateutil.CertificateInfoModel{certificate, otherCertificate},
+			wantErr:               "match the forced code signing identity (iPhone Distribution)",
+		},
+		{
+			name:                  "identity name without separator does not match",
+			expiry:                time.Now().AddDate(0, 1, 0),
+			codeSignIdentity:      "iPhone Dev",
+			installedCertificates: []certificateutil.CertificateInfoModel{certificate},
+			wantErr:               "match the forced code signing identity",
+		},
+		{
+			name:                  "certificate not installed",
+			expiry:                time.Now().AddDate(0, 1, 0),
+			codeSignIdentity:      "iPhone Developer",
+			installedCertificates: []certificateutil.CertificateInfoModel{otherCertificate},
+			wantErr:               "are installed in the keychain",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installTestProfile(t, testProfileUUID, tt.expiry, certificate)
+
+			profile, err := findForcedProvisioningProfile(testProfileUUID)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			err = checkForcedProvisioningProfile(profile, tt.codeSignIdentity, tt.installedCertificates)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
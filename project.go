@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+	"github.com/bitrise-io/xcode-project/xcscheme"
+	"github.com/bitrise-io/xcode-project/xcworkspace"
+)
+
+const defaultAnalyzeConfiguration = "Debug"
+
+// projectArgs returns the xcodebuild arguments selecting the project (or workspace) and the scheme,
+// in the order of the go-xcode xcodebuild command builders.
+func projectArgs(projectPath, scheme string) []string {
+	var args []string
+	if filepath.Ext(projectPath) == ".xcworkspace" {
+		args = append(args, "-workspace", projectPath)
+	} else {
+		args = append(args, "-project", projectPath)
+	}
+	if scheme != "" {
+		args = append(args, "-scheme", scheme)
+	}
+	return args
+}
+
+// schemeTarget is a target built by the scheme's build action.
+type schemeTarget struct {
+	ProjectPath string
+	Name        string
+}
+
+// openScheme returns the scheme by name and the absolute path of the project or workspace containing it.
+func openScheme(projectPath, schemeName string) (*xcscheme.Scheme, string, error) {
+	if xcodeproj.IsXcodeProj(projectPath) {
+		project, err := xcodeproj.Open(projectPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to open project (%s), error: %s", projectPath, err)
+		}
+		return project.Scheme(schemeName)
+	}
+
+	workspace, err := xcworkspace.Open(projectPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open workspace (%s), error: %s", projectPath, err)
+	}
+	return workspace.Scheme(schemeName)
+}
+
+// schemeAnalyzeConfiguration returns the build configuration of the scheme's analyze action.
+// The xcscheme package does not parse the analyze action, so the scheme file is read directly.
+func schemeAnalyzeConfiguration(scheme xcscheme.Scheme) (string, error) {
+	b, err := os.ReadFile(scheme.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var s struct {
+		AnalyzeAction struct {
+			BuildConfiguration string `xml:"buildConfiguration,attr"`
+		}
+	}
+	if err := xml.Unmarshal(b, &s); err != nil {
+		return "", fmt.Errorf("failed to parse scheme (%s), error: %s", scheme.Path, err)
+	}
+
+	if s.AnalyzeAction.BuildConfiguration == "" {
+		return defaultAnalyzeConfiguration, nil
+	}
+	return s.AnalyzeAction.BuildConfiguration, nil
+}
+
+// schemeBuildTargets returns the project targets built by the scheme. Targets from other kind of containers
+// (for example Swift packages) are skipped.
+func schemeBuildTargets(scheme xcscheme.Scheme, containerPath string) ([]schemeTarget, error) {
+	var targets []schemeTarget
+	for _, entry := range scheme.BuildAction.BuildActionEntries {
+		ref := entry.BuildableReference
+		projectPath, err := ref.ReferencedContainerAbsPath(filepath.Dir(containerPath))
+		if err != nil {
+			return nil, err
+		}
+		if !xcodeproj.IsXcodeProj(projectPath) {
+			continue
+		}
+
+		targets = append(targets, schemeTarget{
+			ProjectPath: projectPath,
+			Name:        ref.BlueprintName,
+		})
+	}
+	return targets, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	cache "github.com/bitrise-io/go-xcode/xcodecache"
+)
+
+// retryRemediation is the action taken before retrying a transient failure.
+type retryRemediation string
+
+const (
+	// remediationWait waits for the backoff period, for failures caused by external services or concurrent processes.
+	remediationWait retryRemediation = "wait"
+	// remediationClearSwiftPackages removes the project's Swift package cache (SourcePackages).
+	remediationClearSwiftPackages retryRemediation = "clear Swift package cache"
+	// remediationResetDerivedData removes the content of the project's DerivedData directory, except for the kept paths.
+	remediationResetDerivedData retryRemediation = "reset DerivedData"
+)
+
+// transientFailure is a class of xcodebuild failures which might succeed when retried.
+type transientFailure struct {
+	name        string
+	patterns    []string
+	remediation retryRemediation
+}
+
+func (f transientFailure) matches(output xcodebuildOutput) bool {
+	for _, pattern := range f.patterns {
+		if output.contains(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// transientFailures are the known transient xcodebuild failure classes, in order of precedence.
+var transientFailures = []transientFailure{
+	{
+		name:        "Swift package cache in an invalid state",
+		patterns:    []string{cache.SwiftPackagesStateInvalid},
+		remediation: remediationClearSwiftPackages,
+	},
+	{
+		name: "Swift package fetch network error",
+		patterns: []string{
+			"Failed to clone repository",
+			"Couldn’t update repository submodules",
+			"fatal: unable to access",
+			"Could not resolve host",
+			"The network connection was lost",
+			"The request timed out",
+		},
+		remediation: remediationWait,
+	},
+	{
+		name:        "locked build database",
+		patterns:    []string{"database is locked", "unable to attach DB"},
+		remediation: remediationWait,
+	},
+	{
+		name: "build service crash",
+		patterns: []string{
+			"The build service has encountered an internal inconsistency",
+			"unable to initiate PIF transfer session",
+			"Lost connection to the build service",
+		},
+		remediation: remediationResetDerivedData,
+	},
+	{
+		name:        "Xcode unexpected service error",
+		patterns:    []string{"unexpected service error"},
+		remediation: remediationWait,
+	},
+}
+
+// retryPolicy decides whether a failed xcodebuild command is retried, and remediates the failure before the retry.
+type retryPolicy struct {
+	// maxAttempts is the maximum number of xcodebuild runs, including the first one.
+	maxAttempts int
+	// backoff is the wait before the first retry of a remediationWait failure, it doubles on every retry.
+	backoff  time.Duration
+	failures []transientFailure
+
+	// swiftPackagesPath is empty if the Swift package cache can't be cleared, because the command does not resolve the packages.
+	swiftPackagesPath  string
+	projectDerivedData string
+	// keptPaths are not removed when DerivedData is reset: the Step's stores, and the resolved packages if the command does not resolve them.
+	keptPaths []string
+	// resultBundlePath is removed before retrying, as xcodebuild fails if it already exists.
+	resultBundlePath string
+}
+
+func newRetryPolicy(maxAttempts int, backoff time.Duration, swiftPackagesPath, projectDerivedData string, keptPaths []string, resultBundlePath string) retryPolicy {
+	return retryPolicy{
+		maxAttempts:        maxAttempts,
+		backoff:            backoff,
+		failures:           transientFailures,
+		swiftPackagesPath:  swiftPackagesPath,
+		projectDerivedData: projectDerivedData,
+		keptPaths:          keptPaths,
+		resultBundlePath:   resultBundlePath,
+	}
+}
+
+// classify returns the transient failure class of the output, or nil if the failure is not transient.
+// Failure classes with a remediation not available for the project are skipped.
+func (p retryPolicy) classify(output xcodebuildOutput) *transientFailure {
+	for _, failure := range p.failures {
+		if failure.remediation == remediationClearSwiftPackages && p.swiftPackagesPath == "" ||
+			failure.remediation == remediationResetDerivedData && p.projectDerivedData == "" {
+			continue
+		}
+		if failure.matches(output) {
+			return &failure
+		}
+	}
+	return nil
+}
+
+// shouldRetry returns the transient failure class if the failed attempt should be retried.
+func (p retryPolicy) shouldRetry(attempt int, output xcodebuildOutput, err error) *transientFailure {
+	if err == nil || isTimeoutError(err) || isCancelledError(err) || attempt >= p.maxAttempts {
+		return nil
+	}
+	return p.classify(output)
+}
+
+// delay returns the time remediating the failure of the given attempt waits before the retry.
+func (p retryPolicy) delay(failure transientFailure, attempt int) time.Duration {
+	if failure.remediation != remediationWait {
+		return 0
+	}
+	return p.backoff * time.Duration(1<<(attempt-1))
+}
+
+// remediate prepares the retry following the given (failed) attempt.
+// The backoff wait is interrupted (and a cancelledError is returned) if a termination signal is received.
+func (p retryPolicy) remediate(failure transientFailure, attempt int, signals <-chan os.Signal, logger log.Logger) error {
+	switch failure.remediation {
+	case remediationWait:
+		wait := p.delay(failure, attempt)
+		logger.Printf("Waiting %s before retrying", wait)
+		if err := waitOrCancel(wait, signals); err != nil {
+			return err
+		}
+	case remediationClearSwiftPackages:
+		logger.Printf("Removing Swift package cache: %s", p.swiftPackagesPath)
+		if err := os.RemoveAll(p.swiftPackagesPath); err != nil {
+			return fmt.Errorf("failed to remove invalid Swift package caches, error: %s", err)
+		}
+	case remediationResetDerivedData:
+		logger.Printf("Removing DerivedData: %s", p.projectDerivedData)
+		if len(p.keptPaths) > 0 {
+			logger.Printf("Keeping: %s", strings.Join(p.keptPaths, ", "))
+		}
+		if err := removeAllExcept(p.projectDerivedData, p.keptPaths); err != nil {
+			return fmt.Errorf("failed to remove DerivedData, error: %s", err)
+		}
+	}
+
+	if p.resultBundlePath != "" {
+		if err := os.RemoveAll(p.resultBundlePath); err != nil {
+			return fmt.Errorf("failed to remove result bundle of the failed attempt, error: %s", err)
+		}
+	}
+	return nil
+}
+
+// removeAllExcept removes dir and its content, except for the kept paths (and the directories containing them).
+func removeAllExcept(dir string, keptPaths []string) error {
+	keeping := false
+	for _, kept := range keptPaths {
+		if kept == dir {
+			return nil
+		}
+		if _, ok := relativeTo(dir, kept); ok {
+			keeping = true
+		}
+	}
+	if !keeping {
+		return os.RemoveAll(dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := removeAllExcept(filepath.Join(dir, entry.Name()), keptPaths); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns the policy's settings for logging.
+func (p retryPolicy) String() string {
+	var names []string
+	for _, failure := range p.failures {
+		names = append(names, failure.name)
+	}
+	return fmt.Sprintf("max %d attempts, %s backoff, retried failures: %s", p.maxAttempts, p.backoff, strings.Join(names, ", "))
+}
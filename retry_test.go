@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	cache "github.com/bitrise-io/go-xcode/xcodecache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := newRetryPolicy(3, 10*time.Second, "", "", nil, "")
+	wait := transientFailure{name: "locked build database", remediation: remediationWait}
+
+	assert.Equal(t, 10*time.Second, policy.delay(wait, 1))
+	assert.Equal(t, 20*time.Second, policy.delay(wait, 2))
+	assert.Equal(t, time.Duration(0), policy.delay(transientFailure{remediation: remediationClearSwiftPackages}, 1))
+}
+
+func TestRetryBackoffIsCancelled(t *testing.T) {
+	policy := newRetryPolicy(3, time.Hour, "", "", nil, "")
+	failure := transientFailure{name: "locked build database", remediation: remediationWait}
+
+	signals := make(chan os.Signal, 1)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		signals <- syscall.SIGTERM
+	}()
+
+	startTime := time.Now()
+	err := policy.remediate(failure, 1, signals, log.NewLogger())
+	assert.Less(t, time.Since(startTime), time.Minute)
+	if assert.Error(t, err) {
+		assert.True(t, isCancelledError(err))
+	}
+}
+
+func TestRemoveAllExcept(t *testing.T) {
+	derivedData := t.TempDir()
+	for _, pth := range []string{
+		"Build/Intermediates.noindex/App.build/file.o",
+		"SourcePackages/checkouts/Alamofire/Package.swift",
+		"xcode-analyze-fingerprint/record.json",
+		"xcode-analyze-findings-store.json",
+		"Logs/Build/LogStoreManifest.plist",
+	} {
+		pth = filepath.Join(derivedData, pth)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(pth), 0755)) || !assert.NoError(t, os.WriteFile(pth, nil, 0644)) {
+			return
+		}
+	}
+
+	keptPaths := []string{
+		filepath.Join(derivedData, "SourcePackages"),
+		filepath.Join(derivedData, "xcode-analyze-fingerprint"),
+		filepath.Join(derivedData, "xcode-analyze-findings-store.json"),
+	}
+	if !assert.NoError(t, removeAllExcept(derivedData, keptPaths)) {
+		return
+	}
+
+	for _, pth := range []string{"SourcePackages/checkouts/Alamofire/Package.swift", "xcode-analyze-fingerprint/record.json", "xcode-analyze-findings-store.json"} {
+		assert.FileExists(t, filepath.Join(derivedData, pth))
+	}
+	assert.NoDirExists(t, filepath.Join(derivedData, "Build"))
+	assert.NoDirExists(t, filepath.Join(derivedData, "Logs"))
+
+	// without kept paths, the directory is removed
+	assert.NoError(t, removeAllExcept(derivedData, nil))
+	assert.NoDirExists(t, derivedData)
+
+	// missing directories are not an error
+	assert.NoError(t, removeAllExcept(derivedData, keptPaths))
+}
+
+func TestRetryPolicyClassify(t *testing.T) {
+	invalidPackages := xcodebuildOutput{ErrorLines: []string{"xcodebuild: error: Could not resolve package dependencies: " + cache.SwiftPackagesStateInvalid}}
+	buildServiceCrash := xcodebuildOutput{LastLines: "error: Lost connection to the build service"}
+	lockedDatabase := xcodebuildOutput{LastLines: "error: unable to attach DB: error: accessing build database: database is locked"}
+
+	tests := []struct {
+		name   string
+		policy retryPolicy
+		output xcodebuildOutput
+		want   retryRemediation
+	}{
+		{name: "invalid package cache", policy: newRetryPolicy(2, 0, "/dd/SourcePackages", "/dd", nil, ""), output: invalidPackages, want: remediationClearSwiftPackages},
+		{name: "invalid package cache is not cleared if the command does not resolve the packages", policy: newRetryPolicy(2, 0, "", "/dd", nil, ""), output: invalidPackages},
+		{name: "build service crash", policy: newRetryPolicy(2, 0, "", "/dd", nil, ""), output: buildServiceCrash, want: remediationResetDerivedData},
+		{name: "locked build database", policy: newRetryPolicy(2, 0, "", "", nil, ""), output: lockedDatabase, want: remediationWait},
+		{name: "network error before the last lines", policy: newRetryPolicy(2, 0, "", "", nil, ""), output: xcodebuildOutput{TransientLines: []string{"fatal: unable to access 'https://github.com/org/Kit.git/'"}, LastLines: "** ANALYZE FAILED **"}, want: remediationWait},
+		{name: "not transient", policy: newRetryPolicy(2, 0, "/dd/SourcePackages", "/dd", nil, ""), output: xcodebuildOutput{LastLines: "error: use of undeclared identifier"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := tt.policy.classify(tt.output)
+			if tt.want == "" {
+				assert.Nil(t, failure)
+				return
+			}
+			if assert.NotNil(t, failure) {
+				assert.Equal(t, tt.want, failure.remediation)
+			}
+		})
+	}
+}
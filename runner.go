@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	v1command "github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/progress"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/v2/errorfinder"
+	"github.com/bitrise-io/go-xcode/v2/loginterceptor"
+)
+
+const (
+	// bitriseLogPrefix matches the lines printed by Bitrise build tools (for example the Xcode build time plugin),
+	// these lines are printed directly, bypassing the log formatter.
+	bitriseLogPrefix = `^\[Bitrise.*\].*`
+
+	lastLinesCount     = 20
+	maxDiagnosticLines = 1000
+	maxTransientLines  = 100
+)
+
+// xcodebuildOutput is the result of an xcodebuild run. The full output is only streamed, and not kept in memory.
+type xcodebuildOutput struct {
+	LastLines  string
+	ErrorLines []string
+	// TransientLines are the distinct output lines matching the transientFailures' patterns, wherever they are in the output.
+	TransientLines []string
+	ExitCode       int
+	// AnalyzedFiles are the source files analyzed per target (`<project>/<target>`).
+	AnalyzedFiles map[string][]string
+}
+
+// contains reports whether the error lines, the transient failure lines or the last lines of the output contain s.
+func (o xcodebuildOutput) contains(s string) bool {
+	return strings.Contains(o.LastLines, s) || strings.Contains(strings.Join(o.ErrorLines, "\n"), s) ||
+		strings.Contains(strings.Join(o.TransientLines, "\n"), s)
+}
+
+// xcodebuildRunner runs xcodebuild and streams its output line by line to the log formatter, the xcodebuild log,
+// the diagnostics and transient failure collectors and a bounded tail buffer.
+type xcodebuildRunner struct {
+	logger         log.Logger
+	commandFactory command.Factory
+	outputTool     string
+	formatterArgs  []string
+	log            io.Writer
+	watchdog       *watchdog
+	// masker masks the secrets in the output printed by the log formatter.
+	masker *secretMasker
+	// env are the additional environment variables of xcodebuild.
+	env []string
+}
+
+func newXcodebuildRunner(logger log.Logger, commandFactory command.Factory, outputTool outputToolSelection, xcodebuildLog io.Writer, watchdog *watchdog, masker *secretMasker) *xcodebuildRunner {
+	return &xcodebuildRunner{
+		logger:         logger,
+		commandFactory: commandFactory,
+		outputTool:     outputTool.Name,
+		formatterArgs:  outputTool.ArgsPrefix,
+		log:            xcodebuildLog,
+		watchdog:       watchdog,
+		masker:         masker,
+	}
+}
+
+// setEnv sets the additional environment variables of the next xcodebuild runs.
+func (r *xcodebuildRunner) setEnv(env []string) {
+	r.env = env
+}
+
+// Run runs xcodebuild with the given arguments, using the selected log formatter with the given additional arguments.
+func (r *xcodebuildRunner) Run(workDir string, xcodebuildArgs []string, formatterArgs []string) (xcodebuildOutput, error) {
+	var (
+		tail        = newTailBuffer(lastLinesCount)
+		diagnostics = newDiagnosticsCollector(maxDiagnosticLines)
+		transient   = newTransientLinesCollector(transientFailures, maxTransientLines)
+		analyzed    = newAnalyzedFilesCollector()
+		lines       = newLineWriter(func(line string) {
+			tail.addLine(line)
+			diagnostics.addLine(line)
+			transient.addLine(line)
+		})
+		sinks []io.Writer
+
+		formatterCmd    command.Command
+		formatterInput  *os.File
+		formatterOutput *os.File
+
+		stdout = newMaskingWriter(os.Stdout, r.masker)
+		stderr = newMaskingWriter(os.Stderr, r.masker)
+	)
+
+	if r.outputTool != XcodebuildTool {
+		var err error
+		// An os.Pipe is used (instead of io.Pipe), so that writes fail instead of blocking if the formatter exits early
+		formatterInput, formatterOutput, err = os.Pipe()
+		if err != nil {
+			return xcodebuildOutput{}, fmt.Errorf("failed to create %s input pipe: %w", r.outputTool, err)
+		}
+		sinks = append(sinks, &formatterWriter{out: formatterOutput, outputTool: r.outputTool, logger: r.logger})
+
+		formatterCmd = r.commandFactory.Create(r.outputTool, append(append([]string{}, r.formatterArgs...), formatterArgs...), &command.Opts{
+			Stdin:  formatterInput,
+			Stdout: stdout,
+			Stderr: stderr,
+			Env:    unbufferedIOEnv,
+		})
+	}
+
+	interceptor := loginterceptor.NewPrefixInterceptor(regexp.MustCompile(bitriseLogPrefix), newMaskingWriter(os.Stdout, r.masker), io.MultiWriter(sinks...), r.logger)
+	// Only the formatter is behind the interceptor. The xcodebuild log, the tail, the diagnostics and the analyzed files
+	// are written next to it, as the interceptor drops lines if its sinks (a slow formatter) can't keep up.
+	outputs := []io.Writer{interceptor, r.log, analyzed, lines}
+	if r.watchdog != nil {
+		outputs = append(outputs, r.watchdog)
+	}
+	output := newTeeWriter(r.logger, outputs...)
+
+	// xcodebuild runs in its own process group, so that it can be terminated together with its child processes.
+	// For parallel and concurrent destination testing, it helps to use unbuffered I/O for stdout and to redirect stderr to stdout.
+	buildCmd := newProcessGroupCommand("xcodebuild", xcodebuildArgs, append(append([]string{}, unbufferedIOEnv...), r.env...), workDir)
+	buildCmd.Stdout = output
+	buildCmd.Stderr = output
+	printableBuildCmd := v1command.PrintableCommandArgs(false, buildCmd.Args)
+
+	if formatterCmd != nil {
+		r.logger.TPrintf("$ set -o pipefail && %s | %s", printableBuildCmd, formatterCmd.PrintableCommandArgs())
+	} else {
+		r.logger.TPrintf("$ %s", printableBuildCmd)
+	}
+
+	var err error
+	run := func() {
+		if formatterCmd != nil {
+			if err = formatterCmd.Start(); err != nil {
+				return
+			}
+			// The formatter process holds its own copy of the read end
+			if closeErr := formatterInput.Close(); closeErr != nil {
+				r.logger.Warnf("Failed to close %s input pipe: %s", r.outputTool, closeErr)
+			}
+		}
+		if err = buildCmd.Start(); err != nil {
+			err = fmt.Errorf("failed to start xcodebuild: %w", err)
+			return
+		}
+		if r.watchdog != nil {
+			r.watchdog.start(buildCmd)
+		}
+		err = buildCmd.Wait()
+		if r.watchdog != nil {
+			if timeoutErr := r.watchdog.stop(); timeoutErr != nil {
+				err = timeoutErr
+			}
+		}
+	}
+	if formatterCmd == nil {
+		progress.SimpleProgress(".", time.Minute, run)
+	} else {
+		run()
+	}
+
+	// Deliver all the lines before closing the formatter's input
+	if closeErr := interceptor.Close(); closeErr != nil {
+		r.logger.Warnf("Failed to close log interceptor, error: %s", closeErr)
+	}
+	<-interceptor.TargetDelivered
+	<-interceptor.InterceptedDelivered
+	lines.Flush()
+
+	if formatterCmd != nil {
+		// Close the pipe to the formatter first, otherwise the formatter will not exit
+		if closeErr := formatterOutput.Close(); closeErr != nil {
+			r.logger.Warnf("Failed to close xcodebuild-%s pipe: %s", r.outputTool, closeErr)
+		}
+		if waitErr := formatterCmd.Wait(); waitErr != nil {
+			r.logger.Warnf("%s command failed: %s", r.outputTool, waitErr)
+		}
+		if flushErr := errors.Join(stdout.Flush(), stderr.Flush()); flushErr != nil {
+			r.logger.Warnf("Failed to write %s output: %s", r.outputTool, flushErr)
+		}
+	}
+
+	result := xcodebuildOutput{
+		LastLines:      tail.String(),
+		ErrorLines:     diagnostics.ErrorLines(),
+		TransientLines: transient.Lines(),
+		AnalyzedFiles:  analyzed.Files(),
+	}
+
+	if err != nil {
+		result.ExitCode = -1
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+			err = command.NewExitStatusError(printableBuildCmd, exitErr, result.ErrorLines)
+		}
+	}
+
+	return result, err
+}
+
+// Output runs xcodebuild with the given arguments under the watchdog, without the log formatter and the xcodebuild log,
+// and returns its trimmed standard output. The standard error is only part of the returned error.
+func (r *xcodebuildRunner) Output(workDir string, xcodebuildArgs []string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := newProcessGroupCommand("xcodebuild", xcodebuildArgs, append(append([]string{}, unbufferedIOEnv...), r.env...), workDir)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if r.watchdog != nil {
+		cmd.Stdout = io.MultiWriter(&stdout, r.watchdog)
+		cmd.Stderr = io.MultiWriter(&stderr, r.watchdog)
+	}
+	printableCmd := v1command.PrintableCommandArgs(false, cmd.Args)
+	r.logger.Debugf("$ %s", printableCmd)
+
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to start xcodebuild: %w", err)
+	}
+	if r.watchdog != nil {
+		r.watchdog.start(cmd)
+	}
+	err := cmd.Wait()
+	if r.watchdog != nil {
+		if timeoutErr := r.watchdog.stop(); timeoutErr != nil {
+			err = timeoutErr
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %s: %w", printableCmd, strings.TrimSpace(stderr.String()), err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// formatterWriter writes the log formatter's input, it stops writing (and warns once) after the first failed write.
+type formatterWriter struct {
+	out        io.Writer
+	outputTool string
+	logger     log.Logger
+	failed     bool
+}
+
+// Write ...
+func (w *formatterWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil
+	}
+	if _, err := w.out.Write(p); err != nil {
+		w.failed = true
+		w.logger.Warnf("Failed to write %s input, the remaining output is only written to the xcodebuild log: %s", w.outputTool, err)
+	}
+	return len(p), nil
+}
+
+// teeWriter writes to all of its writers. Unlike io.MultiWriter, a failing writer does not stop the others:
+// it is dropped (with a warning), and the output keeps being written to the rest.
+type teeWriter struct {
+	mu      sync.Mutex
+	writers []io.Writer
+	logger  log.Logger
+}
+
+func newTeeWriter(logger log.Logger, writers ...io.Writer) *teeWriter {
+	return &teeWriter{writers: writers, logger: logger}
+}
+
+// Write ...
+func (w *teeWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	writers := w.writers[:0]
+	for _, writer := range w.writers {
+		n, err := writer.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			w.logger.Warnf("Failed to write the xcodebuild output (%T), it is not written there anymore: %s", writer, err)
+			continue
+		}
+		writers = append(writers, writer)
+	}
+	w.writers = writers
+
+	return len(p), nil
+}
+
+var unbufferedIOEnv = []string{"NSUnbufferedIO=YES"}
+
+// lineWriter calls handleLine with every complete line written to it (without the newline),
+// the last partial line is kept until its newline arrives or until Flush.
+type lineWriter struct {
+	mu         sync.Mutex
+	partial    []byte
+	handleLine func(line string)
+}
+
+func newLineWriter(handleLine func(line string)) *lineWriter {
+	return &lineWriter{handleLine: handleLine}
+}
+
+// Write ...
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	start := 0
+	for {
+		end := bytes.IndexByte(w.partial[start:], '\n')
+		if end < 0 {
+			break
+		}
+		w.handleLine(string(w.partial[start : start+end]))
+		start += end + 1
+	}
+	w.partial = append(w.partial[:0], w.partial[start:]...)
+
+	return len(p), nil
+}
+
+// Flush handles the remaining partial line.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.partial) > 0 {
+		w.handleLine(string(w.partial))
+		w.partial = w.partial[:0]
+	}
+}
+
+// tailBuffer keeps the last lines added to it.
+type tailBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{lines: make([]string, size)}
+}
+
+func (b *tailBuffer) addLine(line string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// String returns the kept lines, in order.
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := b.lines[:b.next]
+	if b.full {
+		lines = append(append([]string{}, b.lines[b.next:]...), b.lines[:b.next]...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// diagnosticsCollector keeps the output lines which might be part of an xcodebuild error,
+// and finds the errors among them with errorfinder.FindXcodebuildErrors once the output ended.
+type diagnosticsCollector struct {
+	mu       sync.Mutex
+	lines    []string
+	maxLines int
+}
+
+func newDiagnosticsCollector(maxLines int) *diagnosticsCollector {
+	return &diagnosticsCollector{maxLines: maxLines}
+}
+
+func (c *diagnosticsCollector) addLine(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	line = strings.TrimSuffix(line, "\r")
+	if len(c.lines) >= c.maxLines || !isDiagnosticLine(line) {
+		return
+	}
+	c.lines = append(c.lines, line)
+}
+
+// ErrorLines returns the xcodebuild errors found in the output.
+func (c *diagnosticsCollector) ErrorLines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return errorfinder.FindXcodebuildErrors(strings.Join(c.lines, "\n"))
+}
+
+// transientLinesCollector keeps the distinct output lines matching the patterns of the transient failures,
+// so that they are found anywhere in the output, not only in its last lines.
+type transientLinesCollector struct {
+	mu       sync.Mutex
+	patterns []string
+	lines    []string
+	seen     map[string]bool
+	maxLines int
+}
+
+func newTransientLinesCollector(failures []transientFailure, maxLines int) *transientLinesCollector {
+	var patterns []string
+	for _, failure := range failures {
+		patterns = append(patterns, failure.patterns...)
+	}
+	return &transientLinesCollector{patterns: patterns, seen: map[string]bool{}, maxLines: maxLines}
+}
+
+func (c *transientLinesCollector) addLine(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	line = strings.TrimSuffix(line, "\r")
+	if len(c.lines) >= c.maxLines || c.seen[line] {
+		return
+	}
+	for _, pattern := range c.patterns {
+		if strings.Contains(line, pattern) {
+			c.seen[line] = true
+			c.lines = append(c.lines, line)
+			return
+		}
+	}
+}
+
+// Lines returns the matching lines, in order.
+func (c *transientLinesCollector) Lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string{}, c.lines...)
+}
+
+// isDiagnosticLine matches the lines errorfinder.FindXcodebuildErrors is looking for.
+func isDiagnosticLine(line string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	return strings.HasPrefix(line, "xcodebuild: error: ") ||
+		strings.HasPrefix(line, "error: ") ||
+		strings.Contains(line, " error: ") ||
+		strings.HasPrefix(line, "Error ") ||
+		strings.HasPrefix(trimmed, "Reason: ") ||
+		strings.HasPrefix(trimmed, "Recovery suggestion: ")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/v2/errorfinder"
+	"github.com/stretchr/testify/assert"
+)
+
+// testXcodebuildOutput generates an xcodebuild analyze output with the given number of compile lines,
+// and a compile error in the middle.
+func testXcodebuildOutput(lines int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "AnalyzeShallow /Users/vagrant/git/App/Sources/File%d.m normal arm64 objective-c com.apple.compilers.llvm.clang.1_0.analyzer (in target 'App' from project 'App')\n", i)
+		fmt.Fprintf(&b, "    cd /Users/vagrant/git/App\n")
+		if i == lines/2 {
+			fmt.Fprintf(&b, "/Users/vagrant/git/App/Sources/File%d.m:12:5: error: use of undeclared identifier 'foo'\n", i)
+		}
+	}
+	b.WriteString("** ANALYZE FAILED **\n")
+	return b.Bytes()
+}
+
+// writeInChunks writes the content the way a pipe delivers it: in fixed size chunks, ignoring the line boundaries.
+func writeInChunks(w io.Writer, content []byte, size int) {
+	for len(content) > 0 {
+		n := min(size, len(content))
+		_, _ = w.Write(content[:n])
+		content = content[n:]
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		chunkSize int
+		want      []string
+	}{
+		{name: "complete lines", content: "first\nsecond\n", chunkSize: 100, want: []string{"first", "second"}},
+		{name: "lines split across writes", content: "first\nsecond\nthird\n", chunkSize: 4, want: []string{"first", "second", "third"}},
+		{name: "partial last line is flushed", content: "first\nsecond", chunkSize: 3, want: []string{"first", "second"}},
+		{name: "empty lines", content: "\n\nlast\n", chunkSize: 1, want: []string{"", "", "last"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			w := newLineWriter(func(line string) { got = append(got, line) })
+
+			writeInChunks(w, []byte(tt.content), tt.chunkSize)
+			w.Flush()
+
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tail := newTailBuffer(3)
+	w := newLineWriter(tail.addLine)
+	writeInChunks(w, []byte("1\n2\n3\n4\n5"), 2)
+	w.Flush()
+
+	assert.Equal(t, "3\n4\n5", tail.String())
+}
+
+func TestDiagnosticsCollector(t *testing.T) {
+	diagnostics := newDiagnosticsCollector(maxDiagnosticLines)
+	w := newLineWriter(diagnostics.addLine)
+	writeInChunks(w, testXcodebuildOutput(1000), 7)
+	w.Flush()
+
+	assert.Equal(t, []string{"/Users/vagrant/git/App/Sources/File500.m:12:5: error: use of undeclared identifier 'foo'"}, diagnostics.ErrorLines())
+}
+
+// BenchmarkOutputProcessing compares the memory use of keeping the complete xcodebuild output in memory
+// (as it was before streaming the output) with streaming it through the tail buffer and the diagnostics collector.
+func BenchmarkOutputProcessing(b *testing.B) {
+	output := testXcodebuildOutput(50000)
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(output)))
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			writeInChunks(&buf, output, 4096)
+
+			out := buf.String()
+			lines := strings.Split(out, "\n")
+			_ = strings.Join(lines[max(0, len(lines)-lastLinesCount):], "\n")
+			_ = errorfinder.FindXcodebuildErrors(out)
+		}
+	})
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(output)))
+		for i := 0; i < b.N; i++ {
+			tail := newTailBuffer(lastLinesCount)
+			diagnostics := newDiagnosticsCollector(maxDiagnosticLines)
+			w := newLineWriter(func(line string) {
+				tail.addLine(line)
+				diagnostics.addLine(line)
+			})
+			writeInChunks(w, output, 4096)
+			w.Flush()
+
+			_ = tail.String()
+			_ = diagnostics.ErrorLines()
+		}
+	})
+}
+
+// fakeXcodebuild puts an xcodebuild shell script with the given body on the PATH.
+func fakeXcodebuild(t *testing.T, script string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if !assert.NoError(t, os.WriteFile(filepath.Join(dir, "xcodebuild"), []byte("#!/bin/sh\n"+script+"\n"), 0755)) {
+		t.FailNow()
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestXcodebuildRunnerOutput(t *testing.T) {
+	fakeXcodebuild(t, `if [ "$1" = "-version" ]; then echo "Xcode 16.0"; echo "Build version 16A242d"; echo "warning: stderr" >&2; exit 0; fi
+echo "error: $1 is not supported" >&2
+exit 64`)
+	runner := &xcodebuildRunner{logger: log.NewLogger(), watchdog: newWatchdog(time.Minute, 0, nil, t.TempDir(), log.NewLogger())}
+
+	out, err := runner.Output(t.TempDir(), []string{"-version"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Xcode 16.0\nBuild version 16A242d", out)
+	}
+
+	_, err = runner.Output(t.TempDir(), []string{"-list"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "error: -list is not supported")
+	}
+}
+
+func TestXcodebuildRunnerOutputTimeout(t *testing.T) {
+	fakeXcodebuild(t, "sleep 30")
+	runner := &xcodebuildRunner{logger: log.NewLogger(), watchdog: newWatchdog(time.Second, 0, nil, t.TempDir(), log.NewLogger())}
+
+	startTime := time.Now()
+	_, err := runner.Output(t.TempDir(), []string{"-showBuildSettings"})
+	if assert.Error(t, err) {
+		assert.True(t, isTimeoutError(err))
+	}
+	assert.Less(t, time.Since(startTime), 5*time.Second)
+}
+
+// failingWriter fails every write.
+type failingWriter struct {
+	writes int
+}
+
+func (w *failingWriter) Write([]byte) (int, error) {
+	w.writes++
+	return 0, io.ErrClosedPipe
+}
+
+func TestTeeWriterToleratesAFailingWriter(t *testing.T) {
+	failing := &failingWriter{}
+	var first, last bytes.Buffer
+	w := newTeeWriter(log.NewLogger(), &first, failing, &last)
+
+	for _, chunk := range []string{"first\n", "second\n"} {
+		n, err := w.Write([]byte(chunk))
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+
+	assert.Equal(t, "first\nsecond\n", first.String())
+	assert.Equal(t, "first\nsecond\n", last.String())
+	// the failing writer is dropped after its first failure
+	assert.Equal(t, 1, failing.writes)
+}
+
+func TestTransientLinesCollector(t *testing.T) {
+	collector := newTransientLinesCollector(transientFailures, 2)
+	w := newLineWriter(collector.addLine)
+	writeInChunks(w, []byte("Resolve Package Graph\r\n"+
+		"Failed to clone repository https://github.com/org/Kit.git:\r\n"+
+		"Failed to clone repository https://github.com/org/Kit.git:\r\n"+
+		"error: database is locked\n"+
+		"fatal: unable to access 'https://github.com/org/Other.git/'\n"), 5)
+	w.Flush()
+
+	assert.Equal(t, []string{"Failed to clone repository https://github.com/org/Kit.git:", "error: database is locked"}, collector.Lines())
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const redactedSecret = "[REDACTED]"
+
+// secretMasker replaces the registered secret values in texts.
+type secretMasker struct {
+	secrets []string
+}
+
+// add registers a secret value, empty values are ignored.
+// The lines of a multi-line secret are registered too, as the output is masked line by line.
+func (m *secretMasker) add(secrets ...string) {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		m.secrets = append(m.secrets, secret)
+		if strings.Contains(secret, "\n") {
+			for _, line := range strings.Split(secret, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					m.secrets = append(m.secrets, line)
+				}
+			}
+		}
+	}
+	// the longer secrets are masked first, so that a secret containing another one is masked entirely
+	sort.SliceStable(m.secrets, func(i, j int) bool { return len(m.secrets[i]) > len(m.secrets[j]) })
+}
+
+func (m *secretMasker) mask(s string) string {
+	for _, secret := range m.secrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+	}
+	return s
+}
+
+// maskingLogger is a log.Logger masking the secrets registered in its secretMasker.
+type maskingLogger struct {
+	log.Logger
+	masker *secretMasker
+}
+
+func newMaskingLogger(logger log.Logger, masker *secretMasker) log.Logger {
+	return &maskingLogger{Logger: logger, masker: masker}
+}
+
+func (l *maskingLogger) sprintf(format string, v ...interface{}) string {
+	return l.masker.mask(fmt.Sprintf(format, v...))
+}
+
+// Infof ...
+func (l *maskingLogger) Infof(format string, v ...interface{}) {
+	l.Logger.Infof("%s", l.sprintf(format, v...))
+}
+
+// Warnf ...
+func (l *maskingLogger) Warnf(format string, v ...interface{}) {
+	l.Logger.Warnf("%s", l.sprintf(format, v...))
+}
+
+// Printf ...
+func (l *maskingLogger) Printf(format string, v ...interface{}) {
+	l.Logger.Printf("%s", l.sprintf(format, v...))
+}
+
+// Donef ...
+func (l *maskingLogger) Donef(format string, v ...interface{}) {
+	l.Logger.Donef("%s", l.sprintf(format, v...))
+}
+
+// Debugf ...
+func (l *maskingLogger) Debugf(format string, v ...interface{}) {
+	l.Logger.Debugf("%s", l.sprintf(format, v...))
+}
+
+// Errorf ...
+func (l *maskingLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Errorf("%s", l.sprintf(format, v...))
+}
+
+// TInfof ...
+func (l *maskingLogger) TInfof(format string, v ...interface{}) {
+	l.Logger.TInfof("%s", l.sprintf(format, v...))
+}
+
+// TWarnf ...
+func (l *maskingLogger) TWarnf(format string, v ...interface{}) {
+	l.Logger.TWarnf("%s", l.sprintf(format, v...))
+}
+
+// TPrintf ...
+func (l *maskingLogger) TPrintf(format string, v ...interface{}) {
+	l.Logger.TPrintf("%s", l.sprintf(format, v...))
+}
+
+// TDonef ...
+func (l *maskingLogger) TDonef(format string, v ...interface{}) {
+	l.Logger.TDonef("%s", l.sprintf(format, v...))
+}
+
+// TDebugf ...
+func (l *maskingLogger) TDebugf(format string, v ...interface{}) {
+	l.Logger.TDebugf("%s", l.sprintf(format, v...))
+}
+
+// TErrorf ...
+func (l *maskingLogger) TErrorf(format string, v ...interface{}) {
+	l.Logger.TErrorf("%s", l.sprintf(format, v...))
+}
+
+// maskingWriter writes the complete lines written to it with the secrets masked, the last partial line is kept until its newline arrives.
+// It is not safe for concurrent use.
+type maskingWriter struct {
+	out     io.Writer
+	masker  *secretMasker
+	partial []byte
+}
+
+func newMaskingWriter(out io.Writer, masker *secretMasker) *maskingWriter {
+	return &maskingWriter{out: out, masker: masker}
+}
+
+// Write ...
+func (w *maskingWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	end := bytes.LastIndexByte(w.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	if _, err := io.WriteString(w.out, w.masker.mask(string(w.partial[:end+1]))); err != nil {
+		return 0, err
+	}
+	w.partial = append(w.partial[:0], w.partial[end+1:]...)
+
+	return len(p), nil
+}
+
+// Flush writes the remaining partial line.
+func (w *maskingWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, w.masker.mask(string(w.partial)))
+	w.partial = nil
+	return err
+}
@@ -0,0 +1,882 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-io/go-utils/stringutil"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-io/go-xcode/xcodebuild"
+	cache "github.com/bitrise-io/go-xcode/xcodecache"
+	"github.com/kballard/go-shellquote"
+)
+
+// analyzeStep holds the resolved configuration and the results shared by the phases of the Step.
+// The phases run in the order of main, a phase is skipped if a previous one failed (xcErr is set).
+type analyzeStep struct {
+	conf   Config
+	logger log.Logger
+	masker *secretMasker
+
+	key                apiKey
+	packageCredentials []packageCredential
+	isForceCodeSign    bool
+
+	workdir     string
+	paths       pathReporter
+	projectPath string
+
+	xcodebuildLog        *xcodebuildLog
+	cmdFactory           command.Factory
+	selectedOutputTool   outputToolSelection
+	outputTool           string
+	formatterArgs        []string
+	formatterReportPaths []string
+	buildSettingsPolicy  buildSettingsPolicy
+	xcresultPath         string
+
+	customOptions       []string
+	isCustomDerivedData bool
+	projectDerivedData  string
+	swiftPackagesPath   string
+	sourcePackagesPath  string
+	fingerprintStoreDir string
+	findingsStorePath   string
+
+	actions                    []string
+	analyzeCmd                 *xcodebuild.CommandBuilder
+	resolvePackagesArgs        []string
+	resolvePackagesRetryPolicy retryPolicy
+	retryPolicy                retryPolicy
+
+	signals                  chan os.Signal
+	cleanups                 cleanupStack
+	removePackageCredentials func(logger log.Logger)
+	packageAuthenticationEnv []string
+	runner                   *xcodebuildRunner
+	analyzeStartTime         time.Time
+
+	xcErr                  error
+	xcodebuildOut          xcodebuildOutput
+	swiftPackagesCache     *swiftPackagesCacheDescriptor
+	buildSettingFindings   []buildSettingFinding
+	buildSettingsAuditPath string
+	fingerprint            string
+	restoredSummary        *analyzeSummary
+	coverageReport         *analysisCoverageReport
+	coverageReportPath     string
+	coverage               []targetCoverage
+	summary                analyzeSummary
+}
+
+// newAnalyzeStep validates the inputs, and creates the masking logger and the xcodebuild log.
+func newAnalyzeStep(conf Config) *analyzeStep {
+	s := &analyzeStep{conf: conf, masker: &secretMasker{}}
+
+	s.key = apiKey{
+		KeyID:    string(conf.APIKeyID),
+		IssuerID: string(conf.APIKeyIssuerID),
+		Path:     conf.APIKeyPath,
+		Content:  string(conf.APIKeyContent),
+	}
+	s.masker.add(s.key.KeyID, s.key.IssuerID, s.key.Content)
+	s.logger = newMaskingLogger(log.NewLogger(log.WithDebugLog(conf.VerboseLog)), s.masker)
+
+	packageCredentials, err := parsePackageCredentials(string(conf.PackageCredentials))
+	if err != nil {
+		s.fail("Invalid package credentials: %s", err)
+	}
+	for _, credential := range packageCredentials {
+		s.masker.add(credential.Token)
+	}
+	if len(packageCredentials) > 0 && !conf.ResolvePackages {
+		s.fail("Package credentials (package_credentials) are only used by the separate package resolution phase, set resolve_packages to yes.")
+	}
+	s.packageCredentials = packageCredentials
+
+	s.workdir, err = resolveWorkdir(conf.Workdir)
+	if err != nil {
+		s.fail("Failed to resolve working directory (%s), error: %s", conf.Workdir, err)
+	}
+	s.paths = newPathReporter(s.workdir)
+	s.key.Path = resolvePath(s.workdir, s.key.Path)
+
+	outputDir, err := resolveDir(s.workdir, conf.OutputDir)
+	if err != nil {
+		s.fail("Invalid output directory (%s), error: %s", conf.OutputDir, err)
+	}
+	s.conf.OutputDir = outputDir
+
+	s.isForceCodeSign = conf.ForceCodeSignIdentity != "" || conf.ForceProvisioningProfile != ""
+	if s.isForceCodeSign && conf.DisableCodesign {
+		s.fail("Code signing is disabled (disable_codesign: yes), but a code signing identity or provisioning profile is forced (force_code_sign_identity, force_provisioning_profile). Set disable_codesign to no or clear the forced code signing inputs.")
+	}
+
+	if s.key.isSet() {
+		if err := s.key.validate(); err != nil {
+			s.fail("Invalid App Store Connect API key inputs: %s", err)
+		}
+		if conf.DisableCodesign {
+			s.logger.Warnf("Code signing is disabled (disable_codesign: yes), the App Store Connect API key is not used")
+			s.key = apiKey{}
+		}
+	}
+
+	if conf.ForceProvisioningProfile != "" {
+		fmt.Println()
+		s.logger.Infof("Checking the forced provisioning profile")
+
+		startTime := time.Now()
+		if err := validateForcedProvisioningProfile(conf.ForceProvisioningProfile, conf.ForceCodeSignIdentity, s.logger); err != nil {
+			s.fail("Invalid forced code signing settings: %s", err)
+		}
+		logDuration(s.logger, "Provisioning profile check", startTime)
+	}
+
+	s.xcodebuildLog, err = newXcodebuildLog(s.conf.OutputDir, conf.CompressXcodebuildLog, s.masker)
+	if err != nil {
+		s.fail("Failed to create xcodebuild log, error: %s", err)
+	}
+
+	s.cmdFactory = command.NewFactory(env.NewRepository())
+
+	return s
+}
+
+// configureOutputs selects the output tool, and prepares the formatter options, the build settings policy and the result bundle path.
+func (s *analyzeStep) configureOutputs() {
+	fmt.Println()
+	s.logger.Infof("Step determined configs:")
+
+	s.projectPath = resolvePath(s.workdir, s.conf.ProjectPath)
+	s.logger.Printf("- Working directory: %s", s.workdir)
+	s.logger.Printf("- Project path: %s", s.paths.rel(s.projectPath))
+
+	outputToolStartTime := time.Now()
+	outputToolChain, err := outputToolChain(s.conf.OutputTool, s.conf.OutputToolFallback)
+	if err != nil {
+		s.fail("Invalid output tool settings: %s", err)
+	}
+	s.logger.Debugf("Output tool chain: %s", strings.Join(outputToolChain, ", "))
+
+	s.selectedOutputTool = selectOutputTool(outputToolChain, s.conf.OutputToolVersion, s.logger, s.cmdFactory)
+	s.outputTool = s.selectedOutputTool.Name
+	if s.selectedOutputTool.Version != nil {
+		s.logger.Printf("- Output tool: %s (%s)", s.outputTool, s.selectedOutputTool.Version.String())
+	} else {
+		s.logger.Printf("- Output tool: %s", s.outputTool)
+	}
+	logDuration(s.logger, "Output tool setup", outputToolStartTime)
+
+	if s.conf.FormatterOptions != "" {
+		if s.outputTool != s.conf.OutputTool {
+			s.logger.Warnf("Formatter options are ignored, as they were given for %s, but %s is used", s.conf.OutputTool, s.outputTool)
+		} else if s.formatterArgs, s.formatterReportPaths, err = parseFormatterOptions(s.outputTool, s.conf.FormatterOptions, s.workdir, s.conf.OutputDir); err != nil {
+			s.fail("Invalid formatter options: %s", err)
+		}
+		s.logger.Debugf("- Formatter options: %s", strings.Join(s.formatterArgs, " "))
+	}
+
+	if s.conf.BuildSettingsAudit != buildSettingsAuditNone {
+		policyPath := s.conf.BuildSettingsPolicyPath
+		if policyPath != "" {
+			policyPath = resolvePath(s.workdir, policyPath)
+		}
+		if s.buildSettingsPolicy, err = loadBuildSettingsPolicy(policyPath); err != nil {
+			s.fail("Invalid build settings policy: %s", err)
+		}
+	}
+
+	// Output files
+	tempDir, err := os.MkdirTemp("", "XCOutput")
+	if err != nil {
+		s.fail("Could not create result bundle path directory: %s", err)
+	}
+	s.xcresultPath = path.Join(tempDir, "Analyze.xcresult")
+
+	//
+	// Cleanup
+	if err := removeFormatterReports(s.formatterReportPaths); err != nil {
+		s.fail("Failed to remove the formatter reports of a previous run, error: %s", err)
+	}
+}
+
+// configureDerivedData parses the additional xcodebuild options, resolves the project's DerivedData path,
+// and cleans the previous analyzer output if requested.
+func (s *analyzeStep) configureDerivedData() {
+	if s.conf.XcodebuildOptions != "" {
+		customOptions, err := shellquote.Split(s.conf.XcodebuildOptions)
+		if err != nil {
+			s.fail("failed to shell split XcodebuildOptions (%s), error: %s", s.conf.XcodebuildOptions, err)
+		}
+		s.customOptions = customOptions
+	}
+
+	// The DerivedData path is either set by the derived_data_path input, or by the -derivedDataPath option
+	derivedDataOption := derivedDataPathOption(s.customOptions)
+	if s.conf.DerivedDataPath != "" && derivedDataOption != "" {
+		s.fail("DerivedData path is set both in the derived_data_path input and in the xcodebuild_options (%s), only one can be used", derivedDataPathFlag)
+	}
+	s.isCustomDerivedData = s.conf.DerivedDataPath != "" || derivedDataOption != ""
+
+	projectDerivedData, err := projectDerivedDataPath(s.projectPath)
+	if err != nil {
+		s.fail("Failed to get DerivedData path, error: %s", err)
+	}
+	if s.conf.DerivedDataPath != "" {
+		projectDerivedData = resolvePath(s.workdir, s.conf.DerivedDataPath)
+	} else if derivedDataOption != "" {
+		projectDerivedData = resolvePath(s.workdir, derivedDataOption)
+	}
+	s.projectDerivedData = projectDerivedData
+
+	// The fingerprint and findings stores are kept when DerivedData is reset before a retry
+	s.fingerprintStoreDir = filepath.Join(projectDerivedData, analyzeFingerprintDirName)
+	s.findingsStorePath = filepath.Join(projectDerivedData, findingsStoreFilename)
+
+	if s.conf.CleanAnalyzerOutput && !s.conf.IsCleanBuild {
+		fmt.Println()
+		s.logger.Infof("Cleaning previous analyzer output")
+
+		startTime := time.Now()
+		if err := cleanAnalyzerOutput(projectDerivedData, s.logger); err != nil {
+			s.fail("Failed to clean analyzer output, error: %s", err)
+		}
+		logDuration(s.logger, "Analyzer output cleanup", startTime)
+	}
+}
+
+// configureAnalyzeCommand creates the analyze and the package resolution commands, and their retry policies.
+func (s *analyzeStep) configureAnalyzeCommand() {
+	//
+	// Analyze project with Xcode Command Line tools
+	fmt.Println()
+	s.logger.Infof("Analyzing the project")
+
+	s.actions = []string{"analyze"}
+	if s.conf.IsCleanBuild {
+		s.actions = []string{"clean", "analyze"}
+	}
+	s.analyzeCmd = xcodebuild.NewCommandBuilder(s.projectPath, s.actions...)
+
+	s.analyzeCmd.SetScheme(s.conf.Scheme)
+
+	if s.conf.DisableCodesign {
+		s.analyzeCmd.SetDisableCodesign(true)
+	}
+
+	if s.conf.DerivedDataPath != "" {
+		s.customOptions = append(s.customOptions, derivedDataPathFlag, s.projectDerivedData)
+	}
+
+	if s.conf.DisableIndexWhileBuilding {
+		s.customOptions = append(s.customOptions, "COMPILER_INDEX_STORE_ENABLE=NO")
+	}
+
+	if s.isForceCodeSign && s.conf.ForceCodeSignMode == forceCodeSignModeBuildSettings {
+		s.customOptions = append(s.customOptions, forceCodeSignBuildSettings(s.conf.ForceCodeSignIdentity, s.conf.ForceProvisioningProfile)...)
+	}
+
+	swiftPackagesPath, err := cache.SwiftPackagesPath(s.projectPath)
+	if err != nil {
+		s.fail("Failed to get Swift Packages path, error: %s", err)
+	}
+	if s.isCustomDerivedData {
+		swiftPackagesPath = filepath.Join(s.projectDerivedData, "SourcePackages")
+	}
+	s.swiftPackagesPath = swiftPackagesPath
+	s.sourcePackagesPath = sourcePackagesDir(s.customOptions, swiftPackagesPath, s.workdir)
+
+	if s.conf.StrictPackageVersions {
+		fmt.Println()
+		s.logger.Infof("Checking the pinned Swift package versions")
+
+		startTime := time.Now()
+		if err := checkPinnedPackageVersions(s.projectPath, s.sourcePackagesPath, s.cmdFactory, s.logger); err != nil {
+			s.fail("Swift package versions check failed: %s", err)
+		}
+		logDuration(s.logger, "Pinned package versions check", startTime)
+
+		if !sliceutil.IsStringInSlice(onlyUsePackageVersionsFromResolvedFileFlag, s.customOptions) {
+			s.customOptions = append(s.customOptions, onlyUsePackageVersionsFromResolvedFileFlag)
+		}
+	}
+
+	if s.conf.ResolvePackages {
+		// the packages are resolved in a separate phase, so the analyze action does not need network access
+		s.resolvePackagesArgs = packageResolutionArgs(s.projectPath, s.conf.Scheme, s.customOptions)
+		if len(s.packageCredentials) > 0 {
+			// xcodebuild reads the credentials from the .netrc file, and the system git applies the URL rewrites
+			if !sliceutil.IsStringInSlice(packageAuthorizationProviderFlag, s.resolvePackagesArgs) {
+				s.resolvePackagesArgs = append(s.resolvePackagesArgs, packageAuthorizationProviderFlag, "netrc")
+			}
+			if !sliceutil.IsStringInSlice(scmProviderFlag, s.resolvePackagesArgs) {
+				s.resolvePackagesArgs = append(s.resolvePackagesArgs, scmProviderFlag, "system")
+			}
+		}
+		if !sliceutil.IsStringInSlice(disableAutomaticPackageResolutionFlag, s.customOptions) {
+			s.customOptions = append(s.customOptions, disableAutomaticPackageResolutionFlag)
+		}
+	}
+
+	s.analyzeCmd.SetCustomOptions(s.customOptions)
+
+	resultBundlePath := ""
+	if !sliceutil.IsStringInSlice("-resultBundlePath", s.customOptions) {
+		resultBundlePath = s.xcresultPath
+		s.analyzeCmd.SetResultBundlePath(resultBundlePath)
+	}
+
+	storePaths := []string{s.fingerprintStoreDir, s.findingsStorePath}
+	backoff := time.Duration(s.conf.RetryBackoff) * time.Second
+	s.resolvePackagesRetryPolicy = newRetryPolicy(s.conf.RetryMaxAttempts, backoff, swiftPackagesPath, s.projectDerivedData, storePaths, "")
+	s.retryPolicy = newRetryPolicy(s.conf.RetryMaxAttempts, backoff, swiftPackagesPath, s.projectDerivedData, storePaths, resultBundlePath)
+	if s.conf.ResolvePackages {
+		// The analysis runs with -disableAutomaticPackageResolution, so it can not resolve the packages again:
+		// the Swift package cache is not cleared, and it is kept when DerivedData is reset.
+		s.retryPolicy = newRetryPolicy(s.conf.RetryMaxAttempts, backoff, "", s.projectDerivedData,
+			append(storePaths, s.sourcePackagesPath), resultBundlePath)
+	}
+}
+
+// prepareBuildEnvironment traps the termination signals, creates the xcodebuild runner,
+// and makes the changes reverted by the cleanup stack: the package credentials, the API key file and the forced code signing settings.
+func (s *analyzeStep) prepareBuildEnvironment() {
+	// The termination signals are trapped before the first change which has to be reverted (package credentials, API key, project files).
+	// While xcodebuild runs, they are forwarded to it, so that the partial results can be exported on cancellation,
+	// between the phases the Step stops at the next checkpoint and cleans up.
+	s.signals = make(chan os.Signal, 1)
+	signal.Notify(s.signals, syscall.SIGTERM, syscall.SIGINT)
+
+	xcodebuildWatchdog := newWatchdog(time.Duration(s.conf.XcodebuildTimeout)*time.Second, time.Duration(s.conf.XcodebuildNoOutputTimeout)*time.Second, s.signals, s.conf.OutputDir, s.logger)
+	s.runner = newXcodebuildRunner(s.logger, s.cmdFactory, s.selectedOutputTool, s.xcodebuildLog, xcodebuildWatchdog, s.masker)
+
+	s.removePackageCredentials = func(log.Logger) {}
+	if len(s.packageCredentials) > 0 {
+		env, cleanup, err := preparePackageAuthentication(s.packageCredentials)
+		s.removePackageCredentials = s.cleanups.push("remove the package credentials", cleanup)
+		if err != nil {
+			s.fail("Failed to prepare package authentication, error: %s", err)
+		}
+		s.packageAuthenticationEnv = env
+	}
+
+	if s.key.isSet() {
+		authentication, cleanup, err := prepareAuthentication(s.key)
+		s.cleanups.push("remove the API key", cleanup)
+		if err != nil {
+			s.fail("Failed to prepare App Store Connect API authentication, error: %s", err)
+		}
+		s.analyzeCmd.SetAuthentication(authentication)
+	}
+
+	if s.isForceCodeSign && s.conf.ForceCodeSignMode == forceCodeSignModeProject {
+		fmt.Println()
+		s.logger.Infof("Forcing code signing settings in the project")
+
+		restore, err := forceCodeSignInProjects(s.projectPath, s.conf.Scheme, s.conf.ForceCodeSignIdentity, s.conf.ForceProvisioningProfile, s.logger)
+		s.cleanups.push("restore project files", restore)
+		if err != nil {
+			s.fail("Failed to force code signing settings, error: %s", err)
+		}
+	}
+}
+
+func (s *analyzeStep) logResolvedSettings() {
+	s.logger.Debugf("Resolved settings:")
+	s.logger.Debugf("- Working directory: %s", s.workdir)
+	s.logger.Debugf("- Project path: %s", s.projectPath)
+	s.logger.Debugf("- Scheme: %s", s.conf.Scheme)
+	s.logger.Debugf("- Actions: %s", strings.Join(s.actions, ", "))
+	s.logger.Debugf("- Output tool: %s", s.outputTool)
+	s.logger.Debugf("- Custom options: %s", strings.Join(s.customOptions, " "))
+	s.logger.Debugf("- Result bundle path: %s", s.xcresultPath)
+	s.logger.Debugf("- Swift packages path: %s", s.swiftPackagesPath)
+	s.logger.Debugf("- Retry policy: %s", s.retryPolicy)
+	s.logger.Debugf("- Forced code signing mode: %s", s.conf.ForceCodeSignMode)
+	s.logger.Debugf("- xcodebuild timeout: %ds, no output timeout: %ds", s.conf.XcodebuildTimeout, s.conf.XcodebuildNoOutputTimeout)
+	s.logger.Debugf("- App Store Connect API authentication: %t", s.key.isSet())
+	if s.conf.ResolvePackages {
+		s.logger.Debugf("$ xcodebuild %s", strings.Join(s.resolvePackagesArgs, " "))
+	}
+	s.logger.Debugf("$ %s", s.analyzeCmd.PrintableCmd())
+}
+
+// checkpoint sets xcErr if a termination signal was received, so that the remaining phases are skipped.
+func (s *analyzeStep) checkpoint() {
+	if s.xcErr != nil {
+		return
+	}
+	if s.xcErr = checkCancelled(s.signals); s.xcErr != nil {
+		s.logger.Println()
+		s.logger.Errorf("%s, skipping the remaining phases", s.xcErr)
+	}
+}
+
+// resolvePackages runs the separate package resolution phase, the only phase using the package credentials.
+func (s *analyzeStep) resolvePackages() {
+	if !s.conf.ResolvePackages || s.xcErr != nil {
+		return
+	}
+
+	fmt.Println()
+	s.logger.Infof("Resolving Swift package dependencies")
+
+	startTime := time.Now()
+	// only the package resolution runs with the package credentials
+	s.runner.setEnv(s.packageAuthenticationEnv)
+	// the formatter options are not used, so that the formatter reports only contain the analysis
+	s.xcodebuildOut, s.xcErr = runCommandWithRetry(s.runner, s.outputTool, nil, s.workdir, s.resolvePackagesArgs, "Package resolution", s.resolvePackagesRetryPolicy, s.logger)
+	if s.xcErr != nil {
+		s.xcErr = &packageResolutionError{err: s.xcErr, failure: s.resolvePackagesRetryPolicy.classify(s.xcodebuildOut)}
+	}
+	logDuration(s.logger, "Package resolution", startTime)
+
+	// the analysis does not resolve packages, so it does not need the credentials
+	s.removePackageCredentials(s.logger)
+	s.runner.setEnv(nil)
+
+	s.prepareSwiftPackagesCache()
+}
+
+// prepareSwiftPackagesCache stores the key of the resolved packages in the Swift package cache directory,
+// it runs right after the packages are resolved.
+func (s *analyzeStep) prepareSwiftPackagesCache() {
+	if s.conf.CacheLevel != cacheLevelSwiftPackagesKeyed || s.xcErr != nil {
+		return
+	}
+
+	fmt.Println()
+	s.logger.Infof("Preparing the key-based Swift packages cache")
+
+	startTime := time.Now()
+	descriptor, err := prepareKeyedSwiftPackagesCache(s.projectPath, s.sourcePackagesPath, s.workdir, s.runner, s.logger)
+	if err != nil {
+		s.logger.Warnf("Failed to prepare the key-based Swift packages cache, error: %s", err)
+	}
+	s.swiftPackagesCache = descriptor
+	logDuration(s.logger, "Swift packages cache key computation", startTime)
+}
+
+func (s *analyzeStep) runBuildSettingsAudit() {
+	if s.conf.BuildSettingsAudit == buildSettingsAuditNone || s.xcErr != nil {
+		return
+	}
+
+	fmt.Println()
+	s.logger.Infof("Auditing the build settings")
+
+	startTime := time.Now()
+	defer logDuration(s.logger, "Build settings audit", startTime)
+
+	findings, err := auditBuildSettings(s.projectPath, s.conf.Scheme, s.customOptions, s.buildSettingsPolicy, s.workdir, s.runner)
+	if err != nil {
+		s.logger.Warnf("Failed to audit the build settings, error: %s", err)
+		return
+	}
+
+	s.buildSettingFindings = findings
+	if len(findings) == 0 {
+		s.logger.Donef("No build setting violates the policy")
+	} else {
+		printBuildSettingsAudit(findings, s.logger)
+		if policyErr := newBuildSettingsPolicyError(findings); policyErr != nil && s.conf.BuildSettingsAudit == buildSettingsAuditFail {
+			// the analysis is not run with the disallowed settings
+			s.xcErr = policyErr
+		} else {
+			s.logger.Warnf("%d build settings violate the policy", len(findings))
+		}
+	}
+
+	if pth, err := writeBuildSettingsAudit(findings, s.conf.OutputDir); err != nil {
+		s.logger.Warnf("%s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman(buildSettingsAuditPathEnvKey, pth); err != nil {
+		s.logger.Warnf("Failed to export: %s, error: %s", buildSettingsAuditPathEnvKey, err)
+	} else {
+		s.buildSettingsAuditPath = pth
+		s.logger.Printf("Exported %s: %s", buildSettingsAuditPathEnvKey, pth)
+	}
+}
+
+// checkAnalyzeFingerprint computes the analyze fingerprint, and restores the outputs of the previous analysis if the fingerprint did not change.
+func (s *analyzeStep) checkAnalyzeFingerprint() {
+	if !s.conf.SkipUnchanged || s.xcErr != nil {
+		return
+	}
+
+	fmt.Println()
+	s.logger.Infof("Computing the analyze fingerprint")
+
+	startTime := time.Now()
+	defer logDuration(s.logger, "Fingerprint computation", startTime)
+
+	configuration := append([]string{s.projectPath, s.conf.Scheme, s.outputTool, strconv.FormatBool(s.conf.CompressXcodebuildLog), strconv.FormatBool(s.conf.CarryOverFindings)}, s.actions...)
+	configuration = append(append(configuration, s.customOptions...), s.formatterArgs...)
+	excludedDirs := []string{s.conf.OutputDir, s.conf.DeployDir, s.projectDerivedData}
+	fingerprint, count, err := computeAnalyzeFingerprint(s.projectPath, s.conf.Scheme, s.customOptions, configuration, excludedDirs, s.workdir, s.runner)
+	if err != nil {
+		s.logger.Warnf("Failed to compute the analyze fingerprint, the analysis can not be skipped, error: %s", err)
+		return
+	}
+	s.fingerprint = fingerprint
+	s.logger.Printf("Fingerprint of %d files and the analyze settings: %s", count, fingerprint)
+
+	if record, err := loadAnalyzeFingerprintRecord(s.fingerprintStoreDir); err != nil {
+		s.logger.Warnf("Failed to load the fingerprint of the previous analysis, error: %s", err)
+	} else if record == nil {
+		s.logger.Printf("No previous analysis found in the cache")
+	} else if record.Fingerprint != fingerprint {
+		s.logger.Printf("The sources or settings changed since the previous analysis (fingerprint: %s)", record.Fingerprint)
+	} else if s.conf.ForceAnalyze {
+		s.logger.Printf("The sources and settings did not change since the previous analysis, but force_analyze is set, analyzing")
+	} else if summary, err := restoreAnalyzeOutputs(s.fingerprintStoreDir, s.conf.OutputDir, *record); err != nil {
+		s.logger.Warnf("Failed to restore the outputs of the previous analysis, analyzing, error: %s", err)
+	} else {
+		s.restoredSummary = &summary
+	}
+}
+
+// analyze runs the analysis, unless the outputs of the previous analysis are restored.
+func (s *analyzeStep) analyze() {
+	if s.restoredSummary != nil {
+		fmt.Println()
+		s.logger.Donef("Skipping the analysis: the sources and settings did not change since the previous analysis (fingerprint: %s), its outputs are restored", s.fingerprint)
+		s.logger.Printf("Set force_analyze to yes to run the analysis anyway")
+		// the xcresult bundle is not cached
+		s.xcresultPath = ""
+	} else if s.xcErr == nil {
+		fmt.Println()
+		s.logger.Infof("Running the analysis")
+
+		startTime := time.Now()
+		s.xcodebuildOut, s.xcErr = runCommandWithRetry(s.runner, s.outputTool, s.formatterArgs, s.workdir, s.analyzeCmd.CommandArgs(), "Analyze", s.retryPolicy, s.logger)
+		logDuration(s.logger, "Analyze", startTime)
+	}
+
+	if !s.conf.ResolvePackages {
+		// the packages are resolved by the analysis
+		s.prepareSwiftPackagesCache()
+	}
+}
+
+// exportXcodebuildOutputs closes the xcodebuild log, and exports it and the result bundle.
+func (s *analyzeStep) exportXcodebuildOutputs() {
+	if err := s.xcodebuildLog.Close(); err != nil {
+		s.logger.Warnf("Failed to write xcodebuild log, error: %s", err)
+	}
+
+	if s.xcErr != nil && s.outputTool != XcodebuildTool {
+		s.logger.Errorf("\nLast lines of the Xcode's build log:")
+		fmt.Println(stringutil.LastNLines(s.masker.mask(s.xcodebuildOut.LastLines), 10))
+		s.logger.Warnf(`You can find the last couple of lines of Xcode's build log above, but the full log is also available in the %s
+	The log file is stored in $BITRISE_DEPLOY_DIR, and its full path is available in the $%s environment variable
+	(value: %s)`, filepath.Base(s.xcodebuildLog.path), xcodebuildLogPathEnvKey, s.xcodebuildLog.path)
+	}
+
+	fmt.Println()
+	for _, envKey := range []string{xcodebuildLogPathEnvKey, bitriseXcodeRawResultTextEnvKey} {
+		if err := tools.ExportEnvironmentWithEnvman(envKey, s.xcodebuildLog.path); err != nil {
+			s.logger.Warnf("Failed to export: %s, error: %s", envKey, err)
+		} else {
+			s.logger.Printf("Exported %s: %s", envKey, s.xcodebuildLog.path)
+		}
+	}
+
+	if s.xcresultPath != "" {
+		// export xcresult bundle
+		if err := tools.ExportEnvironmentWithEnvman("BITRISE_XCRESULT_PATH", s.xcresultPath); err != nil {
+			s.logger.Warnf("Failed to export: BITRISE_XCRESULT_PATH, error: %s", err)
+		} else {
+			s.logger.Printf("Exported BITRISE_XCRESULT_PATH: %s", s.xcresultPath)
+		}
+	}
+}
+
+func (s *analyzeStep) generateCoverageReport() {
+	if !s.conf.CoverageReport || s.restoredSummary != nil || s.xcErr != nil {
+		return
+	}
+
+	fmt.Println()
+	s.logger.Infof("Generating the analysis coverage report")
+
+	startTime := time.Now()
+	defer logDuration(s.logger, "Analysis coverage report", startTime)
+
+	report, err := newAnalysisCoverageReport(s.projectPath, s.conf.Scheme, s.projectDerivedData, s.xcodebuildOut.AnalyzedFiles)
+	if err != nil {
+		s.logger.Warnf("Failed to generate the analysis coverage report, error: %s", err)
+		return
+	}
+	s.coverageReport = &report
+	s.logger.Printf("%d of %d source lines (%.1f%%) are checked by the clang static analyzer", report.AnalyzedLines, report.Lines, report.analyzedPercent())
+
+	if pth, err := writeCoverageReport(report, s.conf.OutputDir); err != nil {
+		s.logger.Warnf("%s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman(coverageReportPathEnvKey, pth); err != nil {
+		s.logger.Warnf("Failed to export: %s, error: %s", coverageReportPathEnvKey, err)
+	} else {
+		s.coverageReportPath = pth
+		s.logger.Printf("Exported %s: %s", coverageReportPathEnvKey, pth)
+	}
+}
+
+func (s *analyzeStep) checkAnalysisCoverage() {
+	if s.conf.ZeroAnalysisCheck == zeroAnalysisCheckNone || s.restoredSummary != nil || s.xcErr != nil {
+		return
+	}
+
+	fmt.Println()
+	s.logger.Infof("Checking the analysis coverage")
+
+	startTime := time.Now()
+	defer logDuration(s.logger, "Analysis coverage check", startTime)
+
+	coverage, err := analysisCoverage(s.projectPath, s.conf.Scheme, s.projectDerivedData, analyzerReportsSince(s.conf.IsCleanBuild, s.analyzeStartTime), s.xcodebuildOut.AnalyzedFiles)
+	if err != nil {
+		s.logger.Warnf("Failed to check the analysis coverage, error: %s", err)
+		return
+	}
+	s.coverage = coverage
+	if notAnalyzed := printAnalysisCoverage(coverage, s.logger); len(notAnalyzed) > 0 {
+		notAnalyzedErr := &notAnalyzedError{targets: notAnalyzed}
+		if s.conf.ZeroAnalysisCheck == zeroAnalysisCheckFail {
+			s.xcErr = notAnalyzedErr
+		} else {
+			s.logger.Warnf("Analyze succeeded, but %s", notAnalyzedErr)
+		}
+	}
+}
+
+// collectAnalysisOutputs creates the analyze summary, either from the restored outputs of the previous analysis,
+// or from the outputs of this one, collecting the formatter and analyzer reports and merging the findings.
+func (s *analyzeStep) collectAnalysisOutputs() {
+	s.summary = newAnalyzeSummary(s.xcodebuildOut, s.xcErr)
+	s.summary.Coverage = s.coverage
+	s.summary.CoverageReportPath = s.coverageReportPath
+	s.summary.XcodebuildLogPath = s.xcodebuildLog.path
+	s.summary.XcresultPath = s.xcresultPath
+	s.summary.Fingerprint = s.fingerprint
+
+	if s.restoredSummary != nil {
+		s.summary = *s.restoredSummary
+		exportRestoredOutputs(s.summary, s.logger)
+		if s.summary.CoverageReportPath != "" {
+			if report, err := loadCoverageReport(s.summary.CoverageReportPath); err != nil {
+				s.logger.Warnf("Failed to load the restored analysis coverage report, error: %s", err)
+			} else {
+				s.coverageReport = &report
+			}
+		}
+		if s.conf.CarryOverFindings {
+			// the findings store did not change, but it needs to be marked for caching in every build
+			if err := commitFindingsStoreCache(s.findingsStorePath); err != nil {
+				s.logger.Warnf("Failed to mark the findings store for caching, error: %s", err)
+			}
+		}
+	} else {
+		if len(s.formatterReportPaths) > 0 {
+			if reportPaths, err := collectFormatterReports(s.formatterReportPaths, s.conf.OutputDir); err != nil {
+				s.logger.Warnf("Failed to collect formatter reports, error: %s", err)
+			} else if len(reportPaths) == 0 {
+				s.logger.Warnf("The output tool did not write any report files")
+			} else if err := tools.ExportEnvironmentWithEnvman(formatterReportPathsEnvKey, strings.Join(reportPaths, "|")); err != nil {
+				s.logger.Warnf("Failed to export: %s, error: %s", formatterReportPathsEnvKey, err)
+			} else {
+				s.summary.FormatterReportPaths = reportPaths
+				s.logger.Printf("Exported %s: %s", formatterReportPathsEnvKey, strings.Join(reportPaths, "|"))
+			}
+		}
+
+		analyzerReportsDir := filepath.Join(s.conf.OutputDir, analyzerReportsDirName)
+		if count, err := collectAnalyzerReports(s.projectDerivedData, s.analyzeStartTime, analyzerReportsDir); err != nil {
+			s.logger.Warnf("Failed to collect analyzer reports, error: %s", err)
+		} else if count > 0 {
+			s.summary.AnalyzerReportsDir = analyzerReportsDir
+			s.summary.AnalyzerReportCount = count
+			s.logger.Printf("Collected %d analyzer reports: %s", count, analyzerReportsDir)
+		}
+
+		s.mergeFindings(analyzerReportsDir)
+	}
+
+	s.summary.BuildSettingsAuditPath = s.buildSettingsAuditPath
+	s.summary.BuildSettingsFindingCount = len(s.buildSettingFindings)
+}
+
+// mergeFindings merges the findings of the analyzer reports with the ones of the previous build.
+func (s *analyzeStep) mergeFindings(analyzerReportsDir string) {
+	if !s.conf.CarryOverFindings || s.xcErr != nil {
+		return
+	}
+
+	fmt.Println()
+	s.logger.Infof("Merging the findings of the previous build")
+
+	startTime := time.Now()
+	defer logDuration(s.logger, "Findings merge", startTime)
+
+	findings, findingsPath, err := mergeCachedFindings(analyzerReportsDir, s.findingsStorePath, s.conf.OutputDir, s.paths, s.logger)
+	if err != nil {
+		s.logger.Warnf("Failed to merge the findings of the previous build, error: %s", err)
+		return
+	}
+	s.summary.FindingsPath = findingsPath
+	s.summary.FindingCount = len(findings.Findings)
+	s.summary.CarriedOverFindingCount = findings.CarriedOverCount
+	s.logger.Printf("%d findings, %d carried over from the previous build", len(findings.Findings), findings.CarriedOverCount)
+
+	for _, env := range [][2]string{
+		{analyzerFindingsPathEnvKey, findingsPath},
+		{findingsStorePathEnvKey, s.findingsStorePath},
+	} {
+		if err := tools.ExportEnvironmentWithEnvman(env[0], env[1]); err != nil {
+			s.logger.Warnf("Failed to export: %s, error: %s", env[0], err)
+		} else {
+			s.logger.Printf("Exported %s: %s", env[0], env[1])
+		}
+	}
+}
+
+// exportSummary writes and exports the analyze summary, its Markdown and HTML reports and the failure reason.
+func (s *analyzeStep) exportSummary() {
+	reportedSummary := s.summary.withRelativePaths(s.paths)
+	if summaryPath, err := writeAnalyzeSummary(reportedSummary, s.conf.OutputDir, s.masker); err != nil {
+		s.logger.Warnf("Failed to write analyze summary, error: %s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman(analyzeSummaryPathEnvKey, summaryPath); err != nil {
+		s.logger.Warnf("Failed to export: %s, error: %s", analyzeSummaryPathEnvKey, err)
+	} else {
+		s.logger.Printf("Exported %s: %s", analyzeSummaryPathEnvKey, summaryPath)
+	}
+
+	if markdownPath, htmlPath, err := writeSummaryReports(reportedSummary, s.coverageReport, s.conf.OutputDir, s.masker); err != nil {
+		s.logger.Warnf("Failed to write the Markdown and HTML summaries, error: %s", err)
+	} else {
+		for _, env := range [][2]string{
+			{markdownSummaryPathEnvKey, markdownPath},
+			{htmlSummaryPathEnvKey, htmlPath},
+		} {
+			if err := tools.ExportEnvironmentWithEnvman(env[0], env[1]); err != nil {
+				s.logger.Warnf("Failed to export: %s, error: %s", env[0], err)
+			} else {
+				s.logger.Printf("Exported %s: %s", env[0], env[1])
+			}
+		}
+	}
+
+	if failure := s.summary.Failure; failure != nil {
+		fmt.Println()
+		s.logger.Errorf("Failure reason: %s", failure.Category)
+		if failure.Location != nil {
+			s.logger.Errorf("First error: %s:%d:%d", s.paths.rel(failure.Location.File), failure.Location.Line, failure.Location.Column)
+		}
+		s.logger.Warnf("Hint: %s", failure.Hint)
+
+		for _, env := range [][2]string{
+			{failureCategoryEnvKey, string(failure.Category)},
+			{failureErrorLinesEnvKey, s.masker.mask(strings.Join(failure.ErrorLines, "\n"))},
+			{failureHintEnvKey, failure.Hint},
+		} {
+			if err := tools.ExportEnvironmentWithEnvman(env[0], env[1]); err != nil {
+				s.logger.Warnf("Failed to export: %s, error: %s", env[0], err)
+			} else {
+				s.logger.Printf("Exported %s", env[0])
+			}
+		}
+	}
+}
+
+// failOnError restores the default signal handling once every output is exported, and fails the Step if any phase failed.
+func (s *analyzeStep) failOnError() {
+	// Everything is exported, the default signal handling (terminating the Step) can be restored
+	signal.Stop(s.signals)
+	if err := checkCancelled(s.signals); err != nil && s.xcErr == nil {
+		// the outputs are exported, but the caches are not prepared
+		s.xcErr = err
+	}
+
+	if isCancelledError(s.xcErr) {
+		s.fail("Analyze cancelled: %s", s.xcErr)
+	} else if isTimeoutError(s.xcErr) {
+		s.fail("Analyze timed out: %s", s.xcErr)
+	} else if isPackageResolutionError(s.xcErr) {
+		s.fail("%s", s.xcErr)
+	} else if isBuildSettingsPolicyError(s.xcErr) {
+		s.fail("Build settings audit failed: %s", s.xcErr)
+	} else if s.xcErr != nil {
+		s.fail("Analyze failed: %s", s.xcErr)
+	}
+}
+
+// collectCaches marks the Swift packages, the analyze fingerprint store and the analyzer intermediates for caching.
+func (s *analyzeStep) collectCaches() {
+	// Cache swift PM
+	switch s.conf.CacheLevel {
+	case cacheLevelSwiftPackages:
+		startTime := time.Now()
+		if !s.isCustomDerivedData {
+			if err := cache.CollectSwiftPackages(s.projectPath); err != nil {
+				s.logger.Warnf("Failed to mark swift packages for caching, error: %s", err)
+			}
+		} else if err := collectSwiftPackages(s.swiftPackagesPath); err != nil {
+			s.logger.Warnf("Failed to mark swift packages for caching, error: %s", err)
+		}
+		logDuration(s.logger, "Swift packages cache collection", startTime)
+	case cacheLevelSwiftPackagesKeyed:
+		if s.swiftPackagesCache != nil {
+			if err := exportSwiftPackagesCacheDescriptor(*s.swiftPackagesCache, s.conf.OutputDir, s.logger); err != nil {
+				s.logger.Warnf("Failed to export the key-based Swift packages cache descriptor, error: %s", err)
+			}
+		}
+	}
+
+	if s.fingerprint != "" {
+		startTime := time.Now()
+		if s.restoredSummary != nil {
+			// the fingerprint store did not change, but it needs to be marked for caching in every build
+			if err := commitAnalyzeFingerprintCache(s.fingerprintStoreDir); err != nil {
+				s.logger.Warnf("Failed to mark the analyze fingerprint for caching, error: %s", err)
+			}
+		} else if err := saveAnalyzeOutputs(s.fingerprintStoreDir, s.conf.OutputDir, s.fingerprint, s.summary); err != nil {
+			s.logger.Warnf("Failed to store the analyze fingerprint and outputs, error: %s", err)
+		}
+
+		if err := tools.ExportEnvironmentWithEnvman(analyzeFingerprintEnvKey, s.fingerprint); err != nil {
+			s.logger.Warnf("Failed to export: %s, error: %s", analyzeFingerprintEnvKey, err)
+		} else {
+			s.logger.Printf("Exported %s: %s", analyzeFingerprintEnvKey, s.fingerprint)
+		}
+		logDuration(s.logger, "Analyze fingerprint store", startTime)
+	}
+
+	if s.conf.CacheIntermediates {
+		startTime := time.Now()
+		if paths, err := collectAnalyzerIntermediates(s.projectDerivedData); err != nil {
+			s.logger.Warnf("Failed to mark analyzer intermediates for caching, error: %s", err)
+		} else if len(paths) == 0 {
+			s.logger.Warnf("No analyzer intermediates found in %s", s.projectDerivedData)
+		} else if err := tools.ExportEnvironmentWithEnvman(analyzerIntermediatesCachePathsEnvKey, strings.Join(paths, "\n")); err != nil {
+			s.logger.Warnf("Failed to export: %s, error: %s", analyzerIntermediatesCachePathsEnvKey, err)
+		} else {
+			s.logger.Printf("Exported %s: %s", analyzerIntermediatesCachePathsEnvKey, strings.Join(paths, ", "))
+		}
+		logDuration(s.logger, "Analyzer intermediates cache collection", startTime)
+	}
+}
+
+// fail reverts the changes of the Step with the cleanup stack, and exits with the error.
+func (s *analyzeStep) fail(format string, v ...interface{}) {
+	s.cleanups.run(s.logger)
+	fail(s.logger, format, v...)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	analyzeSummaryFilename   = "xcode-analyze-summary.json"
+	analyzeSummaryPathEnvKey = "BITRISE_XCODE_ANALYZE_SUMMARY_PATH"
+)
+
+const (
+	analyzeStatusSucceeded = "succeeded"
+	analyzeStatusFailed    = "failed"
+	analyzeStatusTimedOut  = "timed_out"
+	analyzeStatusCancelled = "cancelled"
+	// analyzeStatusPackageResolutionFailed means the analysis did not start, as the Swift package resolution failed.
+	analyzeStatusPackageResolutionFailed = "package_resolution_failed"
+)
+
+// analyzeSummary describes the result of the analyze run and the files it produced.
+// Complete is false if xcodebuild was terminated (timed out or cancelled), in which case the listed files are partial.
+type analyzeSummary struct {
+	Complete   bool     `json:"complete"`
+	Status     string   `json:"status"`
+	Error      string   `json:"error,omitempty"`
+	ErrorLines []string `json:"error_lines,omitempty"`
+	// Failure is the classified reason of the failure.
+	Failure              *analyzeFailure `json:"failure,omitempty"`
+	XcodebuildLogPath    string          `json:"xcodebuild_log_path"`
+	XcresultPath         string          `json:"xcresult_path,omitempty"`
+	AnalyzerReportsDir   string          `json:"analyzer_reports_dir,omitempty"`
+	AnalyzerReportCount  int             `json:"analyzer_report_count"`
+	FormatterReportPaths []string        `json:"formatter_report_paths,omitempty"`
+	// Coverage is the static analyzer coverage of the scheme's targets.
+	Coverage []targetCoverage `json:"analysis_coverage,omitempty"`
+	// CoverageReportPath is the analysis coverage report per target and source language, if it is enabled.
+	CoverageReportPath string `json:"coverage_report_path,omitempty"`
+	// BuildSettingsAuditPath is the findings of the build settings audit, if it is enabled.
+	BuildSettingsAuditPath    string `json:"build_settings_audit_path,omitempty"`
+	BuildSettingsFindingCount int    `json:"build_settings_finding_count,omitempty"`
+	// FindingsPath is the merged findings of this and the previous builds, if carrying over the findings is enabled.
+	FindingsPath            string `json:"findings_path,omitempty"`
+	FindingCount            int    `json:"finding_count,omitempty"`
+	CarriedOverFindingCount int    `json:"carried_over_finding_count,omitempty"`
+	// Skipped is true if the sources and settings did not change since the last analysis with the same Fingerprint,
+	// and its outputs were restored without running the analysis.
+	Skipped     bool   `json:"skipped,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// newAnalyzeSummary creates the summary of the xcodebuild run's result.
+func newAnalyzeSummary(output xcodebuildOutput, err error) analyzeSummary {
+	summary := analyzeSummary{
+		Complete:   true,
+		Status:     analyzeStatusSucceeded,
+		ErrorLines: output.ErrorLines,
+	}
+
+	switch {
+	case err == nil:
+		return summary
+	case isTimeoutError(err):
+		summary.Complete = false
+		summary.Status = analyzeStatusTimedOut
+	case isCancelledError(err):
+		summary.Complete = false
+		summary.Status = analyzeStatusCancelled
+	case isPackageResolutionError(err):
+		summary.Status = analyzeStatusPackageResolutionFailed
+	default:
+		summary.Status = analyzeStatusFailed
+	}
+	summary.Error = err.Error()
+	summary.Failure = classifyFailure(output, err)
+
+	return summary
+}
+
+// withRelativePaths returns a copy of the summary with its paths relative to the working directory (or to the git root),
+// as they are shown in the reports.
+func (s analyzeSummary) withRelativePaths(paths pathReporter) analyzeSummary {
+	for _, pth := range []*string{&s.XcodebuildLogPath, &s.XcresultPath, &s.AnalyzerReportsDir, &s.CoverageReportPath, &s.BuildSettingsAuditPath, &s.FindingsPath} {
+		if *pth != "" {
+			*pth = paths.rel(*pth)
+		}
+	}
+
+	var formatterReportPaths []string
+	for _, pth := range s.FormatterReportPaths {
+		formatterReportPaths = append(formatterReportPaths, paths.rel(pth))
+	}
+	s.FormatterReportPaths = formatterReportPaths
+
+	if s.Failure != nil && s.Failure.Location != nil {
+		failure := *s.Failure
+		location := *failure.Location
+		location.File = paths.rel(location.File)
+		failure.Location = &location
+		s.Failure = &failure
+	}
+	return s
+}
+
+// writeAnalyzeSummary writes the summary as JSON to the output directory, and returns its path.
+func writeAnalyzeSummary(summary analyzeSummary, outputDir string, masker *secretMasker) (string, error) {
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode analyze summary: %w", err)
+	}
+
+	pth := filepath.Join(outputDir, analyzeSummaryFilename)
+	if err := os.WriteFile(pth, []byte(masker.mask(string(content))), 0644); err != nil {
+		return "", fmt.Errorf("failed to write analyze summary (%s): %w", pth, err)
+	}
+	return pth, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	markdownSummaryFilename   = "xcode-analyze-summary.md"
+	markdownSummaryPathEnvKey = "BITRISE_XCODE_ANALYZE_SUMMARY_MARKDOWN_PATH"
+	htmlSummaryFilename       = "xcode-analyze-summary.html"
+	htmlSummaryPathEnvKey     = "BITRISE_XCODE_ANALYZE_SUMMARY_HTML_PATH"
+)
+
+// summaryRow is a labelled value of the summary's overview section.
+type summaryRow struct {
+	Label string
+	Value string
+}
+
+// coverageTableRow is a target's row of the summary's analysis coverage section.
+type coverageTableRow struct {
+	Target string
+	// Languages are the cells of the sourceLanguages columns.
+	Languages         []string
+	AnalyzedLanguages string
+	ExcludedLines     string
+	AnalyzedLines     string
+}
+
+// summaryReport is the content of the Markdown and HTML summaries.
+type summaryReport struct {
+	Overview        []summaryRow
+	Coverage        bool
+	CoverageTotal   string
+	LanguageHeaders []sourceLanguage
+	CoverageRows    []coverageTableRow
+}
+
+// languageCell describes a language's sources of a target: `<files> files, <lines> lines`, marked if the language was analyzed.
+func languageCell(coverage languageCoverage) string {
+	cell := fmt.Sprintf("%d files, %d lines", coverage.Files, coverage.Lines)
+	if coverage.Analyzed {
+		cell += " (analyzed)"
+	}
+	return cell
+}
+
+// newSummaryReport creates the summary content from the analyze summary, and the coverage report if it is given.
+func newSummaryReport(summary analyzeSummary, coverage *analysisCoverageReport) summaryReport {
+	report := summaryReport{
+		Overview: []summaryRow{
+			{Label: "Status", Value: summary.Status},
+			{Label: "Analyzer reports", Value: fmt.Sprint(summary.AnalyzerReportCount)},
+		},
+		LanguageHeaders: sourceLanguages,
+	}
+	if summary.Skipped {
+		report.Overview = append(report.Overview, summaryRow{Label: "Skipped", Value: "the sources and settings did not change, the outputs of the previous analysis are restored"})
+	}
+	if summary.Failure != nil {
+		report.Overview = append(report.Overview,
+			summaryRow{Label: "Failure reason", Value: string(summary.Failure.Category)},
+			summaryRow{Label: "Hint", Value: summary.Failure.Hint},
+		)
+		if location := summary.Failure.Location; location != nil {
+			report.Overview = append(report.Overview, summaryRow{Label: "First error", Value: fmt.Sprintf("%s:%d:%d", location.File, location.Line, location.Column)})
+		}
+	}
+	if summary.FindingsPath != "" {
+		report.Overview = append(report.Overview, summaryRow{Label: "Findings", Value: fmt.Sprintf("%d (%d carried over from the previous build)", summary.FindingCount, summary.CarriedOverFindingCount)})
+	}
+	if summary.BuildSettingsAuditPath != "" {
+		report.Overview = append(report.Overview, summaryRow{Label: "Build settings violations", Value: fmt.Sprint(summary.BuildSettingsFindingCount)})
+	}
+
+	if coverage == nil {
+		return report
+	}
+
+	report.Coverage = true
+	report.CoverageTotal = fmt.Sprintf("%d of %d source lines (%.1f%%) are checked by the clang static analyzer.", coverage.AnalyzedLines, coverage.Lines, coverage.analyzedPercent())
+	for _, target := range coverage.Targets {
+		cells := map[sourceLanguage]string{}
+		for _, language := range target.Languages {
+			cells[language.Language] = languageCell(language)
+		}
+
+		row := coverageTableRow{
+			Target:            targetKey(target.Project, target.Target),
+			AnalyzedLanguages: "-",
+			ExcludedLines:     fmt.Sprintf("%d in %d blocks", target.ExcludedLines, target.ExcludedBlocks),
+			AnalyzedLines:     fmt.Sprintf("%d of %d", target.AnalyzedLines, target.Lines),
+		}
+		for _, language := range sourceLanguages {
+			cell, ok := cells[language]
+			if !ok {
+				cell = "-"
+			}
+			row.Languages = append(row.Languages, cell)
+		}
+		if len(target.AnalyzedLanguages) > 0 {
+			var languages []string
+			for _, language := range target.AnalyzedLanguages {
+				languages = append(languages, string(language))
+			}
+			row.AnalyzedLanguages = strings.Join(languages, ", ")
+		}
+		report.CoverageRows = append(report.CoverageRows, row)
+	}
+	return report
+}
+
+// markdownCell escapes the table cell separators.
+func markdownCell(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// markdown renders the summary as Markdown.
+func (r summaryReport) markdown() string {
+	var b strings.Builder
+	b.WriteString("# Xcode Analyze summary\n\n")
+	for _, row := range r.Overview {
+		fmt.Fprintf(&b, "- **%s**: %s\n", row.Label, row.Value)
+	}
+
+	if !r.Coverage {
+		return b.String()
+	}
+
+	b.WriteString("\n## Analysis coverage\n\n")
+	b.WriteString(r.CoverageTotal + "\n\n")
+
+	headers := []string{"Target"}
+	for _, language := range r.LanguageHeaders {
+		headers = append(headers, string(language))
+	}
+	headers = append(headers, "Analyzed languages", "Excluded lines", "Analyzed lines")
+	b.WriteString("| " + strings.Join(headers, " | ") + " |\n")
+	b.WriteString(strings.Repeat("| --- ", len(headers)) + "|\n")
+	for _, row := range r.CoverageRows {
+		cells := append(append([]string{row.Target}, row.Languages...), row.AnalyzedLanguages, row.ExcludedLines, row.AnalyzedLines)
+		for i, cell := range cells {
+			cells[i] = markdownCell(cell)
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+
+	b.WriteString("\nSwift sources are not checked by the clang static analyzer. ")
+	b.WriteString("Excluded lines are in `#ifndef __clang_analyzer__` blocks.\n")
+	return b.String()
+}
+
+var htmlSummaryTemplate = template.Must(template.New("summary").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Xcode Analyze summary</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>Xcode Analyze summary</h1>
+<ul>
+{{- range .Overview}}
+<li><strong>{{.Label}}</strong>: {{.Value}}</li>
+{{- end}}
+</ul>
+{{- if .Coverage}}
+<h2>Analysis coverage</h2>
+<p>{{.CoverageTotal}}</p>
+<table>
+<tr><th>Target</th>{{range .LanguageHeaders}}<th>{{.}}</th>{{end}}<th>Analyzed languages</th><th>Excluded lines</th><th>Analyzed lines</th></tr>
+{{- range .CoverageRows}}
+<tr><td>{{.Target}}</td>{{range .Languages}}<td>{{.}}</td>{{end}}<td>{{.AnalyzedLanguages}}</td><td>{{.ExcludedLines}}</td><td>{{.AnalyzedLines}}</td></tr>
+{{- end}}
+</table>
+<p>Swift sources are not checked by the clang static analyzer. Excluded lines are in <code>#ifndef __clang_analyzer__</code> blocks.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// html renders the summary as an HTML page, with the values escaped.
+func (r summaryReport) html() (string, error) {
+	var b bytes.Buffer
+	if err := htmlSummaryTemplate.Execute(&b, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// writeSummaryReports writes the Markdown and HTML summaries to the output directory, and returns their paths.
+// The analysis coverage section is added if the coverage report is given.
+// The summary's paths are expected to be relative already (see analyzeSummary.withRelativePaths).
+func writeSummaryReports(summary analyzeSummary, coverage *analysisCoverageReport, outputDir string, masker *secretMasker) (string, string, error) {
+	report := newSummaryReport(summary, coverage)
+
+	markdownPath := filepath.Join(outputDir, markdownSummaryFilename)
+	if err := os.WriteFile(markdownPath, []byte(masker.mask(report.markdown())), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write Markdown summary (%s): %w", markdownPath, err)
+	}
+
+	content, err := report.html()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to render HTML summary: %w", err)
+	}
+	htmlPath := filepath.Join(outputDir, htmlSummaryFilename)
+	if err := os.WriteFile(htmlPath, []byte(masker.mask(content)), 0644); err != nil {
+		return "", "", fmt.Errorf("failed to write HTML summary (%s): %w", htmlPath, err)
+	}
+	return markdownPath, htmlPath, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCoverageReport() *analysisCoverageReport {
+	return &analysisCoverageReport{
+		Targets: []targetCoverageReport{{
+			Project: "App",
+			Target:  "App|Core",
+			Languages: []languageCoverage{
+				{Language: languageSwift, Files: 2, Lines: 30},
+				{Language: languageObjC, Files: 1, Lines: 10, ExcludedLines: 2, Analyzed: true},
+			},
+			AnalyzedLanguages: []sourceLanguage{languageObjC},
+			Lines:             40,
+			AnalyzedLines:     8,
+			ExcludedLines:     2,
+			ExcludedBlocks:    1,
+		}},
+		Lines:         40,
+		AnalyzedLines: 8,
+	}
+}
+
+func TestSummaryReportMarkdown(t *testing.T) {
+	summary := analyzeSummary{Status: "succeeded", AnalyzerReportCount: 3}
+
+	withoutCoverage := newSummaryReport(summary, nil).markdown()
+	assert.Equal(t, "# Xcode Analyze summary\n\n- **Status**: succeeded\n- **Analyzer reports**: 3\n", withoutCoverage)
+
+	got := newSummaryReport(summary, testCoverageReport()).markdown()
+	assert.Contains(t, got, "## Analysis coverage\n\n8 of 40 source lines (20.0%) are checked by the clang static analyzer.\n\n")
+	assert.Contains(t, got, "| Target | "+string(languageSwift)+" | "+string(languageObjC)+" | "+string(languageObjCPP)+" | "+string(languageC)+" | "+string(languageCPP)+" | Analyzed languages | Excluded lines | Analyzed lines |\n")
+	assert.Contains(t, got, "| --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
+	assert.Contains(t, got, `| App/App\|Core | 2 files, 30 lines | 1 files, 10 lines (analyzed) | - | - | - | `+string(languageObjC)+" | 2 in 1 blocks | 8 of 40 |\n")
+}
+
+func TestSummaryReportHTML(t *testing.T) {
+	summary := analyzeSummary{Status: "failed", AnalyzerReportCount: 0, Failure: &analyzeFailure{Category: failureCompileError, Hint: "Fix <the> errors"}}
+
+	withoutCoverage, err := newSummaryReport(summary, nil).html()
+	if assert.NoError(t, err) {
+		assert.Contains(t, withoutCoverage, "<li><strong>Hint</strong>: Fix &lt;the&gt; errors</li>")
+		assert.NotContains(t, withoutCoverage, "Analysis coverage")
+	}
+
+	got, err := newSummaryReport(summary, testCoverageReport()).html()
+	if assert.NoError(t, err) {
+		assert.Contains(t, got, "<h2>Analysis coverage</h2>\n<p>8 of 40 source lines (20.0%) are checked by the clang static analyzer.</p>")
+		assert.Contains(t, got, "<th>"+string(languageSwift)+"</th><th>"+string(languageObjC)+"</th>")
+		assert.Contains(t, got, "<tr><td>App/App|Core</td><td>2 files, 30 lines</td><td>1 files, 10 lines (analyzed)</td><td>-</td><td>-</td><td>-</td><td>"+string(languageObjC)+"</td><td>2 in 1 blocks</td><td>8 of 40</td></tr>")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/xcode-project/serialized"
+	"github.com/bitrise-io/xcode-project/xcodeproj"
+)
+
+// sourceLanguage is the language of a compiled source file.
+type sourceLanguage string
+
+const (
+	languageSwift  sourceLanguage = "Swift"
+	languageObjC   sourceLanguage = "ObjC"
+	languageObjCPP sourceLanguage = "ObjC++"
+	languageC      sourceLanguage = "C"
+	languageCPP    sourceLanguage = "C++"
+)
+
+// sourceLanguages are the compiled source languages, in report order.
+var sourceLanguages = []sourceLanguage{languageSwift, languageObjC, languageObjCPP, languageC, languageCPP}
+
+var sourceLanguageByExtension = map[string]sourceLanguage{
+	".swift": languageSwift,
+	".m":     languageObjC,
+	".mm":    languageObjCPP,
+	".c":     languageC,
+	".cc":    languageCPP,
+	".cp":    languageCPP,
+	".cpp":   languageCPP,
+	".cxx":   languageCPP,
+	".c++":   languageCPP,
+}
+
+// isClangAnalyzable reports whether the clang static analyzer checks the language (all but Swift).
+func (l sourceLanguage) isClangAnalyzable() bool {
+	return l != languageSwift
+}
+
+// targetSource is a source file compiled by a target.
+type targetSource struct {
+	// Path is the absolute path of the file, or its name if the path can not be resolved
+	// (for example it is relative to the SDK or the build products directory).
+	Path     string
+	Language sourceLanguage
+}
+
+// pbxObjects is the objects section of a project.pbxproj file.
+type pbxObjects struct {
+	objects serialized.Object
+	// parents maps the group children to their group.
+	parents map[string]string
+	// sourceRoot is the project directory (SRCROOT).
+	sourceRoot string
+}
+
+func newPBXObjects(project xcodeproj.XcodeProj) (pbxObjects, error) {
+	objects, err := project.RawProj.Object("objects")
+	if err != nil {
+		return pbxObjects{}, err
+	}
+
+	sourceRoot := filepath.Dir(project.Path)
+	if rawProject, err := objects.Object(project.Proj.ID); err == nil {
+		if projectDirPath, err := rawProject.String("projectDirPath"); err == nil && projectDirPath != "" {
+			sourceRoot = filepath.Join(sourceRoot, projectDirPath)
+		}
+	}
+
+	parents := map[string]string{}
+	for id := range objects {
+		object, err := objects.Object(id)
+		if err != nil {
+			continue
+		}
+		children, err := object.StringSlice("children")
+		if err != nil {
+			continue
+		}
+		for _, child := range children {
+			parents[child] = id
+		}
+	}
+
+	return pbxObjects{objects: objects, parents: parents, sourceRoot: sourceRoot}, nil
+}
+
+// resolvePath returns the absolute path of a file reference or group, or an empty string if it is not relative to the project.
+func (o pbxObjects) resolvePath(id string) string {
+	object, err := o.objects.Object(id)
+	if err != nil {
+		return ""
+	}
+	pth, _ := object.String("path")
+	sourceTree, _ := object.String("sourceTree")
+
+	switch sourceTree {
+	case "<absolute>":
+		return pth
+	case "SOURCE_ROOT":
+		return filepath.Join(o.sourceRoot, pth)
+	case "<group>":
+		parent, ok := o.parents[id]
+		if !ok {
+			// the main group
+			return filepath.Join(o.sourceRoot, pth)
+		}
+		parentPath := o.resolvePath(parent)
+		if parentPath == "" {
+			return ""
+		}
+		return filepath.Join(parentPath, pth)
+	default:
+		return ""
+	}
+}
+
+// fileName returns the name of a file reference.
+func (o pbxObjects) fileName(id string) string {
+	object, err := o.objects.Object(id)
+	if err != nil {
+		return ""
+	}
+	if name, err := object.String("name"); err == nil && name != "" {
+		return name
+	}
+	pth, _ := object.String("path")
+	return filepath.Base(pth)
+}
+
+// targetSources returns the source files compiled by the target: the files of its sources build phase,
+// and the source files in its (Xcode 16) file system synchronized groups (without applying the groups' membership exceptions).
+func targetSources(project xcodeproj.XcodeProj, targetName string) ([]targetSource, error) {
+	target, ok := project.Proj.TargetByName(targetName)
+	if !ok {
+		return nil, fmt.Errorf("target (%s) not found in project (%s)", targetName, project.Path)
+	}
+
+	objects, err := newPBXObjects(project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project (%s) objects: %w", project.Path, err)
+	}
+
+	rawTarget, err := objects.objects.Object(target.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var sources []targetSource
+	add := func(pth, name string) {
+		language, ok := sourceLanguageByExtension[strings.ToLower(filepath.Ext(name))]
+		if !ok {
+			return
+		}
+		if pth == "" {
+			pth = name
+		}
+		if seen[pth] {
+			return
+		}
+		seen[pth] = true
+		sources = append(sources, targetSource{Path: pth, Language: language})
+	}
+
+	buildPhaseIDs, _ := rawTarget.StringSlice("buildPhases")
+	for _, buildPhaseID := range buildPhaseIDs {
+		buildPhase, err := objects.objects.Object(buildPhaseID)
+		if err != nil {
+			return nil, err
+		}
+		if isa, _ := buildPhase.String("isa"); isa != "PBXSourcesBuildPhase" {
+			continue
+		}
+
+		buildFileIDs, _ := buildPhase.StringSlice("files")
+		for _, buildFileID := range buildFileIDs {
+			buildFile, err := objects.objects.Object(buildFileID)
+			if err != nil {
+				return nil, err
+			}
+			fileRef, err := buildFile.String("fileRef")
+			if err != nil {
+				// Swift package products have a productRef instead
+				continue
+			}
+			add(objects.resolvePath(fileRef), objects.fileName(fileRef))
+		}
+	}
+
+	groupIDs, _ := rawTarget.StringSlice("fileSystemSynchronizedGroups")
+	for _, groupID := range groupIDs {
+		dir := objects.resolvePath(groupID)
+		if dir == "" {
+			continue
+		}
+		if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				add(pth, info.Name())
+			}
+			return nil
+		}); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to list the synchronized group (%s): %w", dir, err)
+		}
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Path < sources[j].Path
+	})
+	return sources, nil
+}
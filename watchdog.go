@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+const (
+	// terminateGracePeriod is the time xcodebuild gets to exit after SIGTERM, before it is killed.
+	terminateGracePeriod = 30 * time.Second
+	// cancelGracePeriod is the time xcodebuild gets to exit after the forwarded termination signal, before it is killed.
+	// It is shorter than terminateGracePeriod, as the CI kills the Step shortly after cancelling it.
+	cancelGracePeriod = 10 * time.Second
+	watchdogInterval  = time.Second
+)
+
+// timeoutError is returned when xcodebuild is terminated by the watchdog.
+type timeoutError struct {
+	noOutput bool
+	timeout  time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	if e.noOutput {
+		return fmt.Sprintf("xcodebuild did not print any output for %s", e.timeout)
+	}
+	return fmt.Sprintf("xcodebuild did not finish in %s (including the package resolution and the retries)", e.timeout)
+}
+
+func isTimeoutError(err error) bool {
+	var timeoutErr *timeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+// cancelledError is returned when xcodebuild is terminated, or the next phase of the Step is not started,
+// because the Step received a termination signal.
+type cancelledError struct {
+	signal os.Signal
+}
+
+func (e *cancelledError) Error() string {
+	return fmt.Sprintf("the analysis was cancelled (%s)", e.signal)
+}
+
+func isCancelledError(err error) bool {
+	var cancelledErr *cancelledError
+	return errors.As(err, &cancelledErr)
+}
+
+// checkCancelled returns a cancelledError if a termination signal was received, it does not block.
+// While xcodebuild runs, the watchdog receives the signals, checkCancelled is used between the phases of the Step.
+func checkCancelled(signals <-chan os.Signal) error {
+	select {
+	case sig := <-signals:
+		return &cancelledError{signal: sig}
+	default:
+		return nil
+	}
+}
+
+// waitOrCancel waits for d, and returns a cancelledError if a termination signal is received in the meantime.
+func waitOrCancel(d time.Duration, signals <-chan os.Signal) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case sig := <-signals:
+		return &cancelledError{signal: sig}
+	case <-timer.C:
+		return nil
+	}
+}
+
+// watchdog terminates the xcodebuild process group, if the xcodebuild runs (all phases and attempts together) take longer than the timeout,
+// or xcodebuild does not print anything for longer than the no output timeout. Zero timeouts are disabled.
+// The timeout's deadline starts when the watchdog is created.
+// It tracks the output by being one of xcodebuild's output writers.
+// The termination signals received on the signals channel are forwarded to the process group.
+type watchdog struct {
+	timeout time.Duration
+	// deadline is the end of the timeout, it is zero if the timeout is disabled.
+	deadline        time.Time
+	noOutputTimeout time.Duration
+	signals         <-chan os.Signal
+	// sampleDir is where the stack samples of the hung processes are written.
+	sampleDir string
+	logger    log.Logger
+
+	lastOutput atomic.Int64
+	exited     chan struct{}
+	wg         sync.WaitGroup
+	err        error
+}
+
+func newWatchdog(timeout, noOutputTimeout time.Duration, signals <-chan os.Signal, sampleDir string, logger log.Logger) *watchdog {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	return &watchdog{
+		timeout:         timeout,
+		deadline:        deadline,
+		noOutputTimeout: noOutputTimeout,
+		signals:         signals,
+		sampleDir:       sampleDir,
+		logger:          logger,
+	}
+}
+
+// Write records the time of the latest output.
+func (w *watchdog) Write(p []byte) (int, error) {
+	w.lastOutput.Store(time.Now().UnixNano())
+	return len(p), nil
+}
+
+// hasTimeLeft reports whether the deadline is not reached after waiting d.
+func (w *watchdog) hasTimeLeft(d time.Duration) bool {
+	return w.deadline.IsZero() || time.Now().Add(d).Before(w.deadline)
+}
+
+// start starts watching the process group led by the given (already started) command.
+func (w *watchdog) start(cmd *exec.Cmd) {
+	w.exited = make(chan struct{})
+	w.err = nil
+	if w.timeout <= 0 && w.noOutputTimeout <= 0 && w.signals == nil {
+		return
+	}
+
+	w.lastOutput.Store(time.Now().UnixNano())
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(watchdogInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.exited:
+				return
+			case sig := <-w.signals:
+				w.err = &cancelledError{signal: sig}
+				w.logger.Println()
+				w.logger.Errorf("Received %s, forwarding it to xcodebuild", sig)
+				forwarded, ok := sig.(syscall.Signal)
+				if !ok {
+					forwarded = syscall.SIGTERM
+				}
+				terminateProcessGroup(cmd.Process.Pid, forwarded, cancelGracePeriod, w.exited, w.logger)
+				return
+			case now := <-ticker.C:
+				var err *timeoutError
+				if !w.deadline.IsZero() && now.After(w.deadline) {
+					err = &timeoutError{timeout: w.timeout}
+				} else if w.noOutputTimeout > 0 && now.Sub(time.Unix(0, w.lastOutput.Load())) > w.noOutputTimeout {
+					err = &timeoutError{noOutput: true, timeout: w.noOutputTimeout}
+				}
+				if err == nil {
+					continue
+				}
+
+				w.err = err
+				w.logger.Println()
+				w.logger.Errorf("%s, terminating it", err)
+				w.dumpProcesses(cmd.Process.Pid)
+				terminateProcessGroup(cmd.Process.Pid, syscall.SIGTERM, terminateGracePeriod, w.exited, w.logger)
+				return
+			}
+		}
+	}()
+}
+
+// stop stops watching, it has to be called after the command exited.
+// Returns the timeout (or cancelled) error if the command was terminated by the watchdog.
+func (w *watchdog) stop() error {
+	close(w.exited)
+	w.wg.Wait()
+	return w.err
+}
+
+// dumpProcesses prints the processes of the process group, and samples their call stacks if the sample tool is available (macOS).
+func (w *watchdog) dumpProcesses(pgid int) {
+	out, err := exec.Command("pgrep", "-g", strconv.Itoa(pgid)).Output()
+	if err != nil {
+		w.logger.Warnf("Failed to list the running processes: %s", err)
+		return
+	}
+	pids := strings.Fields(string(out))
+	if len(pids) == 0 {
+		return
+	}
+
+	if out, err := exec.Command("ps", "-o", "pid,etime,command", "-p", strings.Join(pids, ",")).CombinedOutput(); err != nil {
+		w.logger.Warnf("Failed to list the running processes: %s", err)
+	} else {
+		w.logger.Printf("Running processes:\n%s", out)
+	}
+
+	if _, err := exec.LookPath("sample"); err != nil {
+		w.logger.Debugf("Call stacks are not sampled: %s", err)
+		return
+	}
+	for _, pid := range pids {
+		pth := filepath.Join(w.sampleDir, fmt.Sprintf("xcodebuild-hang-sample-%s.txt", pid))
+		if out, err := exec.Command("sample", pid, "1", "-file", pth).CombinedOutput(); err != nil {
+			w.logger.Warnf("Failed to sample process %s: %s: %s", pid, out, err)
+			continue
+		}
+		w.logger.Printf("Call stacks of process %s: %s", pid, pth)
+	}
+}
+
+// terminateProcessGroup sends the signal to the process group, and kills it if it does not exit in the grace period.
+func terminateProcessGroup(pgid int, sig syscall.Signal, gracePeriod time.Duration, exited <-chan struct{}, logger log.Logger) {
+	if err := syscall.Kill(-pgid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		logger.Warnf("Failed to terminate xcodebuild: %s", err)
+	}
+
+	select {
+	case <-exited:
+		return
+	case <-time.After(gracePeriod):
+	}
+
+	logger.Warnf("xcodebuild did not exit in %s, killing it", gracePeriod)
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		logger.Warnf("Failed to kill xcodebuild: %s", err)
+	}
+}
+
+// newProcessGroupCommand creates a command which runs in its own process group, so that it can be terminated with its child processes.
+func newProcessGroupCommand(name string, args []string, env []string, dir string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Child processes might keep the output pipes open after xcodebuild exited
+	cmd.WaitDelay = terminateGracePeriod
+	return cmd
+}
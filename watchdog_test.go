@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// runWatched runs the command in its own process group, watched by the watchdog, and returns the watchdog's error.
+func runWatched(t *testing.T, w *watchdog, name string, args ...string) error {
+	t.Helper()
+
+	cmd := newProcessGroupCommand(name, args, nil, t.TempDir())
+	if !assert.NoError(t, cmd.Start()) {
+		t.FailNow()
+	}
+	w.start(cmd)
+	_ = cmd.Wait()
+	return w.stop()
+}
+
+func TestWatchdogTimeoutIsSharedByTheRuns(t *testing.T) {
+	w := newWatchdog(2*time.Second, 0, nil, t.TempDir(), log.NewLogger())
+
+	// the first run finishes in time, but it uses up most of the timeout
+	assert.NoError(t, runWatched(t, w, "sleep", "1"))
+
+	startTime := time.Now()
+	err := runWatched(t, w, "sleep", "30")
+	if assert.Error(t, err) {
+		assert.True(t, isTimeoutError(err))
+	}
+	assert.Less(t, time.Since(startTime), 5*time.Second)
+}
+
+func TestWatchdogNoOutputTimeout(t *testing.T) {
+	w := newWatchdog(0, time.Second, nil, t.TempDir(), log.NewLogger())
+
+	err := runWatched(t, w, "sleep", "30")
+	if assert.Error(t, err) {
+		assert.True(t, isTimeoutError(err))
+		assert.Contains(t, err.Error(), "did not print any output")
+	}
+}
+
+func TestWatchdogHasTimeLeft(t *testing.T) {
+	assert.True(t, newWatchdog(0, 0, nil, "", log.NewLogger()).hasTimeLeft(time.Hour))
+
+	w := newWatchdog(time.Minute, 0, nil, "", log.NewLogger())
+	assert.True(t, w.hasTimeLeft(10*time.Second))
+	assert.False(t, w.hasTimeLeft(2*time.Minute))
+}
+
+func TestWatchdogForwardsTheTerminationSignal(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	w := newWatchdog(0, 0, signals, t.TempDir(), log.NewLogger())
+
+	cmd := newProcessGroupCommand("sleep", []string{"30"}, nil, t.TempDir())
+	if !assert.NoError(t, cmd.Start()) {
+		return
+	}
+	w.start(cmd)
+
+	startTime := time.Now()
+	signals <- syscall.SIGTERM
+	waitErr := cmd.Wait()
+	err := w.stop()
+
+	assert.Less(t, time.Since(startTime), cancelGracePeriod)
+	assert.Error(t, waitErr)
+	if assert.Error(t, err) {
+		assert.True(t, isCancelledError(err))
+	}
+}
+
+func TestCheckCancelled(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	assert.NoError(t, checkCancelled(signals))
+	assert.NoError(t, checkCancelled(nil))
+
+	signals <- syscall.SIGINT
+	err := checkCancelled(signals)
+	if assert.Error(t, err) {
+		assert.True(t, isCancelledError(err))
+	}
+}
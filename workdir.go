@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveWorkdir returns the absolute path of the Step's working directory, the current directory is used if workdir is empty.
+func resolveWorkdir(workdir string) (string, error) {
+	if workdir == "" {
+		return os.Getwd()
+	}
+
+	absWorkdir, err := filepath.Abs(workdir)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(absWorkdir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", absWorkdir)
+	}
+	return absWorkdir, nil
+}
+
+// resolveDir returns the absolute path of an existing directory, a relative dir is resolved against the working directory.
+func resolveDir(workdir, dir string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("no directory given")
+	}
+
+	pth := resolvePath(workdir, dir)
+	info, err := os.Stat(pth)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", pth)
+	}
+	return pth, nil
+}
+
+// resolvePath returns the absolute path of pth, relative paths are resolved against the working directory.
+func resolvePath(workdir, pth string) string {
+	if pth == "" || filepath.IsAbs(pth) {
+		return filepath.Clean(pth)
+	}
+	return filepath.Join(workdir, pth)
+}
+
+// findGitRoot returns the closest parent directory (including dir) containing a .git entry, or an empty string.
+func findGitRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// pathReporter shortens paths in logs and reports: paths are made relative to the working directory,
+// or to the git root if they are outside of the working directory.
+type pathReporter struct {
+	workdir string
+	gitRoot string
+}
+
+func newPathReporter(workdir string) pathReporter {
+	return pathReporter{
+		workdir: workdir,
+		gitRoot: findGitRoot(workdir),
+	}
+}
+
+func (r pathReporter) rel(pth string) string {
+	for _, base := range []string{r.workdir, r.gitRoot} {
+		if base == "" {
+			continue
+		}
+		if relPth, ok := relativeTo(base, pth); ok {
+			return relPth
+		}
+	}
+	return pth
+}
+
+func relativeTo(base, pth string) (string, bool) {
+	relPth, err := filepath.Rel(base, pth)
+	if err != nil || relPth == ".." || strings.HasPrefix(relPth, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return relPth, true
+}
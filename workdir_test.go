@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathReporterRel(t *testing.T) {
+	gitRoot := t.TempDir()
+	if !assert.NoError(t, os.Mkdir(filepath.Join(gitRoot, ".git"), 0755)) {
+		return
+	}
+	workdir := filepath.Join(gitRoot, "ios")
+	if !assert.NoError(t, os.Mkdir(workdir, 0755)) {
+		return
+	}
+	paths := newPathReporter(workdir)
+
+	tests := []struct {
+		name string
+		pth  string
+		want string
+	}{
+		{name: "path in the working directory", pth: filepath.Join(workdir, "App", "main.m"), want: filepath.Join("App", "main.m")},
+		{name: "path outside of the working directory, in the git root", pth: filepath.Join(gitRoot, "shared", "util.m"), want: filepath.Join("shared", "util.m")},
+		{name: "path outside of the git root", pth: "/tmp/deploy/summary.json", want: "/tmp/deploy/summary.json"},
+		{name: "relative path", pth: "App/main.m", want: "App/main.m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, paths.rel(tt.pth))
+		})
+	}
+}
+
+func TestAnalyzeSummaryWithRelativePaths(t *testing.T) {
+	paths := pathReporter{workdir: "/src/ios"}
+	summary := analyzeSummary{
+		XcodebuildLogPath:    "/src/ios/output/xcodebuild.log",
+		XcresultPath:         "/tmp/XCOutput/Analyze.xcresult",
+		FormatterReportPaths: []string{"/src/ios/output/report.html"},
+		FindingsPath:         "/src/ios/output/xcode-analyzer-findings.json",
+		Failure: &analyzeFailure{
+			Category: failureCompileError,
+			Location: &sourceLocation{File: "/src/ios/App/main.m", Line: 12, Column: 3},
+		},
+	}
+
+	relative := summary.withRelativePaths(paths)
+
+	assert.Equal(t, "output/xcodebuild.log", relative.XcodebuildLogPath)
+	assert.Equal(t, "/tmp/XCOutput/Analyze.xcresult", relative.XcresultPath)
+	assert.Equal(t, []string{"output/report.html"}, relative.FormatterReportPaths)
+	assert.Equal(t, "output/xcode-analyzer-findings.json", relative.FindingsPath)
+	assert.Equal(t, "", relative.CoverageReportPath)
+	assert.Equal(t, "App/main.m", relative.Failure.Location.File)
+
+	// the original summary keeps the absolute paths, which are exported and stored with the fingerprint
+	assert.Equal(t, "/src/ios/output/xcodebuild.log", summary.XcodebuildLogPath)
+	assert.Equal(t, []string{"/src/ios/output/report.html"}, summary.FormatterReportPaths)
+	assert.Equal(t, "/src/ios/App/main.m", summary.Failure.Location.File)
+}
+
+func TestResolveDir(t *testing.T) {
+	workdir := t.TempDir()
+	outputDir := filepath.Join(workdir, "output")
+	file := filepath.Join(workdir, "file.txt")
+	if !assert.NoError(t, os.Mkdir(outputDir, 0755)) || !assert.NoError(t, os.WriteFile(file, nil, 0644)) {
+		return
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{name: "relative to the working directory", dir: "output", want: outputDir},
+		{name: "absolute", dir: outputDir + "/", want: outputDir},
+		{name: "empty", dir: "", wantErr: true},
+		{name: "missing", dir: "missing", wantErr: true},
+		{name: "file", dir: "file.txt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDir(workdir, tt.dir)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const xcodebuildLogPathEnvKey = "BITRISE_XCODEBUILD_LOG_PATH"
+
+// xcodebuildLog streams the raw xcodebuild output to a (optionally gzip compressed) file, masking the secrets line by line.
+// It is safe for concurrent use.
+type xcodebuildLog struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	gzip    *gzip.Writer
+	out     io.Writer
+	masker  *secretMasker
+	partial []byte
+}
+
+// newXcodebuildLog creates (or truncates) the log file in the output directory.
+func newXcodebuildLog(outputDir string, compress bool, masker *secretMasker) (*xcodebuildLog, error) {
+	pth := filepath.Join(outputDir, xcodebuildLogFilename)
+	if compress {
+		pth += ".gz"
+	}
+
+	file, err := os.Create(pth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create xcodebuild log file (%s), error: %s", pth, err)
+	}
+
+	l := &xcodebuildLog{
+		path:   pth,
+		file:   file,
+		out:    file,
+		masker: masker,
+	}
+	if compress {
+		l.gzip = gzip.NewWriter(file)
+		l.out = l.gzip
+	}
+	return l, nil
+}
+
+// Write writes the complete lines of p to the log file, the last partial line is kept until its newline arrives.
+func (l *xcodebuildLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	end := bytes.LastIndexByte(l.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	if _, err := io.WriteString(l.out, l.masker.mask(string(l.partial[:end+1]))); err != nil {
+		return 0, err
+	}
+	l.partial = append(l.partial[:0], l.partial[end+1:]...)
+
+	return len(p), nil
+}
+
+// Close flushes the remaining partial line and closes the log file.
+func (l *xcodebuildLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.partial) > 0 {
+		if _, err := io.WriteString(l.out, l.masker.mask(string(l.partial))); err != nil {
+			return err
+		}
+		l.partial = nil
+	}
+	if l.gzip != nil {
+		if err := l.gzip.Close(); err != nil {
+			return err
+		}
+	}
+	return l.file.Close()
+}